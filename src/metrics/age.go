@@ -0,0 +1,114 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package metrics
+
+import (
+	"time"
+
+	errWrap "metrics/error"
+)
+
+// Touch sets a Time metric to the current time of the clock of the Metrics,
+// ex. on every heartbeat
+//
+// Time metrics can also be moved with IncreaseMetricValue and
+// DecreaseMetricValue by a time.Duration
+//
+// metricName Name of the Time metric
+// returns error if specified metric does not exist or is not a Time
+func (metric Metrics) Touch(metricName string) error {
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+
+	metricTypeStr := metric.metricData.GetMetricType(metricName)
+	switch metricCapabilitiesMap[metricTypeStr] {
+	case Time:
+	case InvalidMetric:
+		return errWrap.MetricNotFound{MetricName: metricName}
+	default:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: metricTypeStr, MetricOperation: "Touch"}
+	}
+
+	now := metric.options.clock.Now()
+	return metric.change(metricName, func() error {
+		return metric.metricData.SetMetricValue(metricName, now)
+	})
+}
+
+// ReadMetricAge returns the time elapsed since the value of a Time metric,
+// at the current time of the clock of the Metrics
+//
+// metricName Name of the Time metric
+// returns error if specified metric does not exist, is not a Time or was
+// never set (wrapping ValueAssertionInvalid)
+func (metric Metrics) ReadMetricAge(metricName string) (time.Duration, error) {
+	value, err := metric.ReadMetric(metricName)
+	if err != nil {
+		return 0, err
+	}
+
+	timeValue, ok := value.(time.Time)
+	if !ok {
+		return 0, errWrap.ValueAssertionInvalid{Value: value, ExpectedType: "time.Time"}
+	}
+	if timeValue.IsZero() {
+		return 0, errWrap.ValueAssertionInvalid{Value: value, ExpectedType: "time.Time that was set"}
+	}
+	return metric.Now().Sub(timeValue), nil
+}
+
+// SetStaleAfter sets the age after which a Time metric is stale, ex. the
+// last heartbeat is older than 30s
+//
+// Exporters report the staleness of the Time metrics with a stale age.
+//
+// metricName Name of the Time metric (or of a labeled series)
+// maxAge Age after which the metric is stale, 0 to stop checking it
+func (metric Metrics) SetStaleAfter(metricName string, maxAge time.Duration) {
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+
+	if maxAge <= 0 {
+		delete(metric.options.staleAfter, metricName)
+		return
+	}
+	metric.options.staleAfter[metricName] = maxAge
+}
+
+// IsStale returns whether a Time metric is older than the age set with
+// SetStaleAfter, Time metrics that were never set are stale
+//
+// metricName Name of the Time metric
+// returns false if no stale age is set, error if specified metric does not
+// exist or is not a Time
+func (metric Metrics) IsStale(metricName string) (bool, error) {
+	metric.mutex.Lock()
+	maxAge, ok := metric.options.staleAfter[metricName]
+	metric.mutex.Unlock()
+
+	value, err := metric.ReadMetric(metricName)
+	if err != nil {
+		return false, err
+	}
+	timeValue, isTime := value.(time.Time)
+	if !isTime {
+		return false, errWrap.ValueAssertionInvalid{Value: value, ExpectedType: "time.Time"}
+	}
+	if !ok {
+		return false, nil
+	}
+	return isStale(timeValue, maxAge, metric.Now()), nil
+}
+
+// isStale Whether a time is older than an age
+func isStale(value time.Time, maxAge time.Duration, now time.Time) bool {
+	return value.IsZero() || now.Sub(value) > maxAge
+}
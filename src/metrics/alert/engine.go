@@ -0,0 +1,619 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+// Package alert evaluates threshold rules on metrics and dispatches the
+// alerts to sinks (log, callback, webhook), for the self-monitoring of
+// daemons with no monitoring system nearby.
+//
+//	engine := alert.NewEngine(globalMetrics, alert.Options{
+//		Interval: 15 * time.Second,
+//		Sinks:    []alert.Sink{alert.LogSink(nil)},
+//	})
+//	err := engine.AddRule(alert.Rule{Name: "errors", Expr: "error > 100", Clear: "80"})
+//	engine.Start()
+//	defer engine.Close()
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	gometrics "metrics"
+	errWrap "metrics/error"
+	"metrics/telemetry"
+)
+
+// DefaultInterval Interval between the evaluations of the rules
+const DefaultInterval = 15 * time.Second
+
+// State State of an alert
+type State int
+
+// States of the alerts
+const (
+	// Inactive The condition is false
+	Inactive State = iota
+	// Pending The condition is true, but not for the duration of the rule yet
+	Pending
+	// Firing The condition is true (for the duration of the rule)
+	Firing
+	// Resolved The alert fired and its value cleared since
+	Resolved
+)
+
+var stateNames = map[State]string{
+	Inactive: "inactive",
+	Pending:  "pending",
+	Firing:   "firing",
+	Resolved: "resolved",
+}
+
+// String returns the name of the state, ex. firing
+func (s State) String() string {
+	return stateNames[s]
+}
+
+// MarshalText encodes the state as its name
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Alert State of a rule, sent to the sinks when it fires and resolves
+type Alert struct {
+	Rule    string            `json:"rule"`
+	Expr    string            `json:"expr"`
+	Summary string            `json:"summary,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	State   State             `json:"state"`
+	// Value Value of the last evaluation
+	Value float64 `json:"value"`
+	// Since Time of the last change of state
+	Since time.Time `json:"since"`
+	// Err Error of the last evaluation (ex. the metric does not exist), the
+	// state does not change on errors
+	Err error `json:"-"`
+}
+
+// FunctionWindows Source of the sliding windows of the traced functions,
+// ex. a *telemetry.Telemetry
+type FunctionWindows interface {
+	GetFunctionWindows() map[string][]gometrics.WindowSnapshot
+}
+
+// globalTelemetry Windows of the global Telemetry
+type globalTelemetry struct{}
+
+// GetFunctionWindows returns the windows of the global Telemetry
+func (globalTelemetry) GetFunctionWindows() map[string][]gometrics.WindowSnapshot {
+	return telemetry.GetFunctionWindows()
+}
+
+// Options Settings of an Engine
+type Options struct {
+	// Interval Interval between the evaluations started by Start,
+	// DefaultInterval if 0
+	Interval time.Duration
+	// Telemetry Source of the values of the telemetry rules, the global
+	// Telemetry if nil
+	Telemetry FunctionWindows
+	// Sinks Destinations of the notifications
+	Sinks []Sink
+	// OnError Called with the errors of the evaluations started by Start,
+	// they are ignored if nil
+	OnError func(error)
+}
+
+// ruleState Rule with the state of its alert
+type ruleState struct {
+	rule      Rule
+	condition condition
+	alert     Alert
+	// clearedSince When the value of a firing alert cleared
+	clearedSince time.Time
+	// Previous sample of the value, for rates
+	previous     float64
+	previousTime time.Time
+}
+
+// Engine Evaluates rules on metrics and notifies the sinks of the alerts
+// that fire and resolve
+type Engine struct {
+	mutex     sync.Mutex
+	metrics   gometrics.Metrics
+	telemetry FunctionWindows
+	options   Options
+	rules     []*ruleState
+	sinks     []Sink
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewEngine returns an Engine evaluating rules on metrics
+//
+// The rules are evaluated at the time of the clock of the metrics (see
+// Metrics.SetClock), by Evaluate or periodically once started
+//
+// metrics Metrics the rules are evaluated on
+// options Settings of the Engine
+func NewEngine(metrics gometrics.Metrics, options Options) *Engine {
+	if options.Interval <= 0 {
+		options.Interval = DefaultInterval
+	}
+	source := options.Telemetry
+	if source == nil {
+		source = globalTelemetry{}
+	}
+	return &Engine{
+		metrics:   metrics,
+		telemetry: source,
+		options:   options,
+		sinks:     append([]Sink{}, options.Sinks...),
+	}
+}
+
+// AddRule adds a rule, its alert starts inactive
+//
+// returns error (wrapping errWrap.RuleInvalid) if the rule can not be
+// parsed, its function does not apply to its metric (if the metric exists),
+// its duration thresholds can not be converted to the unit of its metric or
+// its name is already used
+func (e *Engine) AddRule(rule Rule) error {
+	c, err := parseRule(rule)
+	if err != nil {
+		return err
+	}
+	if !c.telemetry {
+		_, unit := e.metrics.GetDescription(c.name)
+		if reason := checkFunction(c, e.metrics.GetMetricType(c.name), unit); reason != "" {
+			return errWrap.RuleInvalid{Rule: rule.Name, Expr: rule.Expr, Reason: reason}
+		}
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for _, state := range e.rules {
+		if state.rule.Name == rule.Name {
+			return errWrap.RuleInvalid{Rule: rule.Name, Expr: rule.Expr, Reason: "a rule with the same name exists"}
+		}
+	}
+
+	labels := make(map[string]string, len(rule.Labels))
+	for name, value := range rule.Labels {
+		labels[name] = value
+	}
+	rule.Labels = labels
+	e.rules = append(e.rules, &ruleState{
+		rule:      rule,
+		condition: c,
+		alert: Alert{
+			Rule:    rule.Name,
+			Expr:    rule.Expr,
+			Summary: rule.Summary,
+			Labels:  labels,
+			State:   Inactive,
+			Since:   e.metrics.Now(),
+		},
+	})
+	return nil
+}
+
+// RemoveRule removes a rule, without notifying its alert
+//
+// name Name of the rule
+func (e *Engine) RemoveRule(name string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for i, state := range e.rules {
+		if state.rule.Name == name {
+			e.rules = append(e.rules[:i], e.rules[i+1:]...)
+			return
+		}
+	}
+}
+
+// AddSink adds a destination of the notifications
+func (e *Engine) AddSink(sink Sink) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.sinks = append(e.sinks, sink)
+}
+
+// GetAlerts returns the state of the alerts of all the rules, in the order
+// the rules were added
+func (e *Engine) GetAlerts() []Alert {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	alerts := make([]Alert, len(e.rules))
+	for i, state := range e.rules {
+		alerts[i] = state.alert
+	}
+	return alerts
+}
+
+// Evaluate evaluates all the rules now and notifies the sinks of the alerts
+// that fired or resolved
+//
+// The sinks are called one after the other once the rules are evaluated.
+//
+// returns the evaluation and sink errors, joined
+func (e *Engine) Evaluate() error {
+	e.mutex.Lock()
+	now := e.metrics.Now()
+	var windows map[string][]gometrics.WindowSnapshot
+	var errs []error
+	var notifications []Alert
+	for _, state := range e.rules {
+		if state.condition.telemetry && windows == nil {
+			windows = e.telemetry.GetFunctionWindows()
+		}
+
+		value, ok, err := e.read(state, windows, now)
+		state.alert.Err = err
+		if err != nil {
+			errs = append(errs, fmt.Errorf("alert %s: %w", state.rule.Name, err))
+			continue
+		}
+		if !ok {
+			// No data yet (ex. first sample of a rate)
+			continue
+		}
+		state.alert.Value = value
+		if state.update(value, now) {
+			notifications = append(notifications, state.alert)
+		}
+	}
+	sinks := append([]Sink{}, e.sinks...)
+	e.mutex.Unlock()
+
+	for _, notification := range notifications {
+		for _, sink := range sinks {
+			if err := sink.Notify(notification); err != nil {
+				errs = append(errs, fmt.Errorf("alert %s: %w", notification.Rule, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Start evaluates the rules on every interval until Close, does nothing if
+// the Engine is already started
+func (e *Engine) Start() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.stop != nil {
+		return
+	}
+	e.stop = make(chan struct{})
+	e.done = make(chan struct{})
+	go e.run(e.stop, e.done)
+}
+
+// run Evaluate the rules on every interval until stopped
+func (e *Engine) run(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(e.options.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := e.Evaluate(); err != nil && e.options.OnError != nil {
+				e.options.OnError(err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Close stops the evaluations started by Start and waits for the current one
+// to end, the Engine can be started again
+func (e *Engine) Close() {
+	e.mutex.Lock()
+	stop, done := e.stop, e.done
+	e.stop, e.done = nil, nil
+	e.mutex.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// update Update the state of the alert of a rule with a new value
+//
+// returns whether the alert fired or resolved
+func (s *ruleState) update(value float64, now time.Time) bool {
+	c := s.condition
+	switch s.alert.State {
+	case Inactive, Resolved:
+		if !compare(value, c.operator, c.threshold) {
+			return false
+		}
+		if c.duration > 0 {
+			s.setState(Pending, now)
+			return false
+		}
+		s.setState(Firing, now)
+		return true
+
+	case Pending:
+		if !compare(value, c.operator, c.threshold) {
+			s.setState(Inactive, now)
+			return false
+		}
+		if now.Sub(s.alert.Since) < c.duration {
+			return false
+		}
+		s.setState(Firing, now)
+		return true
+
+	default:
+		// Firing until the value crosses the clear threshold for KeepFiring
+		if compare(value, c.operator, c.clear) {
+			s.clearedSince = time.Time{}
+			return false
+		}
+		if s.clearedSince.IsZero() {
+			s.clearedSince = now
+		}
+		if now.Sub(s.clearedSince) < s.rule.KeepFiring {
+			return false
+		}
+		s.clearedSince = time.Time{}
+		s.setState(Resolved, now)
+		return true
+	}
+}
+
+// setState Change the state of the alert of a rule
+func (s *ruleState) setState(state State, now time.Time) {
+	s.alert.State = state
+	s.alert.Since = now
+}
+
+// read Read the value of a rule
+//
+// windows Windows of the traced functions, for the telemetry rules
+// returns false if there is no value yet
+func (e *Engine) read(state *ruleState, windows map[string][]gometrics.WindowSnapshot, now time.Time) (float64, bool, error) {
+	c := state.condition
+	if c.telemetry {
+		window, err := functionWindow(windows, c.name)
+		if err != nil {
+			return 0, false, err
+		}
+		if c.function == "rate" {
+			return float64(window.Count) / window.Span.Seconds(), true, nil
+		}
+		// The latencies of the traced functions are in milliseconds
+		return readWindow(c, window, 1)
+	}
+
+	value, err := e.metrics.ReadMetric(c.name)
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch metricValue := value.(type) {
+	case int, float64:
+		number := toFloat64(metricValue)
+		switch c.function {
+		case "":
+			return number, true, nil
+		case "rate":
+			return state.rate(number, now)
+		}
+
+	case time.Time:
+		if c.function == "age" {
+			// Time metrics that were never set have no age yet
+			if metricValue.IsZero() {
+				return 0, false, nil
+			}
+			return float64(now.Sub(metricValue)) / float64(time.Millisecond), true, nil
+		}
+
+	case gometrics.MeterSnapshot:
+		switch c.function {
+		case "", "count":
+			return float64(metricValue.Count), true, nil
+		case "rate":
+			return metricValue.Rate1, true, nil
+		}
+
+	case gometrics.HistogramValue:
+		scale, err := e.durationScale(state)
+		if err != nil {
+			return 0, false, err
+		}
+		switch {
+		case c.percentile > 0:
+			if metricValue.Count == 0 {
+				return 0, false, nil
+			}
+			return metricValue.Percentile(c.percentile) * scale, true, nil
+		case c.function == "count":
+			return float64(metricValue.Count), true, nil
+		case c.function == "avg":
+			if metricValue.Count == 0 {
+				return 0, false, nil
+			}
+			return metricValue.Sum / float64(metricValue.Count) * scale, true, nil
+		}
+
+	case gometrics.RatioSnapshot:
+		// Without a denominator there is no ratio yet
+		switch c.function {
+		case "":
+			if metricValue.Denominator == 0 {
+				return 0, false, nil
+			}
+			return metricValue.Ratio, true, nil
+		case "count":
+			return float64(metricValue.Denominator), true, nil
+		case "rate":
+			return state.rate(float64(metricValue.Denominator), now)
+		}
+
+	case gometrics.TimerSnapshot:
+		// Durations are compared in milliseconds, like the duration
+		// thresholds
+		if c.function == "count" {
+			return float64(metricValue.Count), true, nil
+		}
+		if c.function == "rate" {
+			return state.rate(float64(metricValue.Count), now)
+		}
+		if metricValue.Count == 0 {
+			return 0, false, nil
+		}
+		switch {
+		case c.percentile > 0:
+			// Clamped to the durations observed, like the percentiles of
+			// the TimerSnapshot
+			value := metricValue.Histogram.Percentile(c.percentile)
+			value = math.Max(value, float64(metricValue.Min)/float64(time.Millisecond))
+			value = math.Min(value, float64(metricValue.Max)/float64(time.Millisecond))
+			return value, true, nil
+		case c.function == "avg":
+			return float64(metricValue.Mean) / float64(time.Millisecond), true, nil
+		case c.function == "min":
+			return float64(metricValue.Min) / float64(time.Millisecond), true, nil
+		case c.function == "max":
+			return float64(metricValue.Max) / float64(time.Millisecond), true, nil
+		}
+
+	case []gometrics.WindowSnapshot:
+		if len(metricValue) == 0 {
+			return 0, false, nil
+		}
+		if c.function == "rate" {
+			return metricValue[0].Rate, true, nil
+		}
+		scale, err := e.durationScale(state)
+		if err != nil {
+			return 0, false, err
+		}
+		return readWindow(c, metricValue[0], scale)
+	}
+
+	if c.function == "" {
+		return 0, false, errWrap.ValueAssertionInvalid{Value: value, ExpectedType: "number"}
+	}
+	return 0, false, errWrap.MetricInvalidOperation{MetricName: c.name, MetricType: fmt.Sprintf("%T", value), MetricOperation: c.function}
+}
+
+// durationScale Milliseconds per unit of the Histogram or Window metric of
+// a rule with duration thresholds, from the unit set with Describe
+//
+// returns 1 if the thresholds are not durations, and error if the unit is
+// not a known duration unit
+func (e *Engine) durationScale(state *ruleState) (float64, error) {
+	if !state.condition.durations || !isDurationFunction(state.condition) {
+		return 1, nil
+	}
+
+	_, unit := e.metrics.GetDescription(state.condition.name)
+	perUnit, known := durationUnits[unit]
+	if !known {
+		return 0, errWrap.RuleInvalid{Rule: state.rule.Name, Expr: state.rule.Expr,
+			Reason: "duration thresholds need the unit of the metric, set with Describe (ex. seconds)"}
+	}
+	return float64(perUnit) / float64(time.Millisecond), nil
+}
+
+// rate Per second rate of a Counter or Fraction since the previous
+// evaluation, the whole value counts if it went down (ex. reset)
+func (s *ruleState) rate(value float64, now time.Time) (float64, bool, error) {
+	previous, previousTime := s.previous, s.previousTime
+	s.previous, s.previousTime = value, now
+	if previousTime.IsZero() || !now.After(previousTime) {
+		return 0, false, nil
+	}
+
+	increase := value - previous
+	if increase < 0 {
+		increase = value
+	}
+	return increase / now.Sub(previousTime).Seconds(), true, nil
+}
+
+// readWindow Read a function of a window: pNN, avg, min, max or count
+//
+// scale Factor converting the observations, ex. to milliseconds
+func readWindow(c condition, window gometrics.WindowSnapshot, scale float64) (float64, bool, error) {
+	if c.function == "count" {
+		return float64(window.Count), true, nil
+	}
+	if window.Count == 0 {
+		return 0, false, nil
+	}
+
+	switch {
+	case c.function == "avg":
+		return window.Sum / float64(window.Count) * scale, true, nil
+	case c.function == "min":
+		return window.Min * scale, true, nil
+	case c.function == "max":
+		return window.Max * scale, true, nil
+	case c.percentile == 0.5:
+		return window.P50 * scale, true, nil
+	case c.percentile == 0.9:
+		return window.P90 * scale, true, nil
+	case c.percentile == 0.99:
+		return window.P99 * scale, true, nil
+	}
+	return 0, false, errWrap.MetricInvalidOperation{MetricName: c.name, MetricType: "Window", MetricOperation: c.function}
+}
+
+// functionWindow Find the first window of a traced function, by its full
+// name (ex. main.taskA()) or its name without package and parentheses (ex.
+// taskA)
+func functionWindow(windows map[string][]gometrics.WindowSnapshot, name string) (gometrics.WindowSnapshot, error) {
+	if spans, ok := windows[name]; ok && len(spans) > 0 {
+		return spans[0], nil
+	}
+
+	matches := []string{}
+	for functionName, spans := range windows {
+		short := strings.TrimSuffix(functionName, "()")
+		if len(spans) > 0 && (short == name || strings.HasSuffix(short, "."+name)) {
+			matches = append(matches, functionName)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return gometrics.WindowSnapshot{}, errWrap.MetricNotFound{MetricName: "telemetry " + name}
+	case 1:
+		return windows[matches[0]][0], nil
+	default:
+		sort.Strings(matches)
+		return gometrics.WindowSnapshot{}, fmt.Errorf("telemetry %s is ambiguous: %s", name, strings.Join(matches, ", "))
+	}
+}
+
+// toFloat64 Convert a Counter or Fraction value
+func toFloat64(value interface{}) float64 {
+	if count, ok := value.(int); ok {
+		return float64(count)
+	}
+	return value.(float64)
+}
@@ -0,0 +1,300 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package alert
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	gometrics "metrics"
+	"metrics/clock"
+	errWrap "metrics/error"
+)
+
+// fakeWindows Windows of traced functions
+type fakeWindows map[string][]gometrics.WindowSnapshot
+
+// GetFunctionWindows returns the windows
+func (f fakeWindows) GetFunctionWindows() map[string][]gometrics.WindowSnapshot {
+	return f
+}
+
+// testEngine Engine on metrics timed by a manual clock, recording its
+// notifications
+type testEngine struct {
+	*Engine
+	metrics       gometrics.Metrics
+	clock         *clock.Manual
+	notifications []string
+}
+
+// newTestEngine Create an Engine on new metrics
+//
+// metrics Initial metrics, as given to NewMetrics
+// windows Windows of the telemetry rules
+func newTestEngine(metrics map[string]interface{}, windows fakeWindows) *testEngine {
+	te := &testEngine{
+		metrics: gometrics.NewMetrics(metrics),
+		clock:   clock.NewManual(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+	}
+	te.metrics.SetClock(te.clock)
+	te.Engine = NewEngine(te.metrics, Options{
+		Telemetry: windows,
+		Sinks: []Sink{SinkFunc(func(alert Alert) error {
+			te.notifications = append(te.notifications, alert.Rule+" "+alert.State.String())
+			return nil
+		})},
+	})
+	return te
+}
+
+// step One evaluation of a rule
+type step struct {
+	// advance Time the clock moves before the evaluation
+	advance time.Duration
+	// value Value the metric is set to before the evaluation
+	value interface{}
+	want  State
+}
+
+// run Evaluate a rule on a metric after every step and check its state
+func (te *testEngine) run(t *testing.T, metricName string, steps []step) {
+	t.Helper()
+
+	for i, step := range steps {
+		te.clock.Advance(step.advance)
+		if err := te.metrics.SetMetric(metricName, step.value); err != nil {
+			t.Fatalf("step %d: SetMetric() error = %v", i, err)
+		}
+		if err := te.Evaluate(); err != nil {
+			t.Fatalf("step %d: Evaluate() error = %v", i, err)
+		}
+		if got := te.GetAlerts()[0].State; got != step.want {
+			t.Errorf("step %d: state = %s, want %s", i, got, step.want)
+		}
+	}
+}
+
+func TestEngineStates(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  Rule
+		steps []step
+		// Notifications sent to the sinks, in order
+		want []string
+	}{
+		{
+			name: "fires at once",
+			rule: Rule{Name: "errors", Expr: "error > 100"},
+			steps: []step{
+				{value: 50, want: Inactive},
+				{advance: time.Minute, value: 120, want: Firing},
+				{advance: time.Minute, value: 90, want: Resolved},
+				{advance: time.Minute, value: 130, want: Firing},
+			},
+			want: []string{"errors firing", "errors resolved", "errors firing"},
+		},
+		{
+			name: "pending for the duration",
+			rule: Rule{Name: "errors", Expr: "error > 100 for 2m"},
+			steps: []step{
+				{value: 120, want: Pending},
+				{advance: time.Minute, value: 130, want: Pending},
+				{advance: time.Minute, value: 130, want: Firing},
+				{advance: time.Minute, value: 130, want: Firing},
+			},
+			want: []string{"errors firing"},
+		},
+		{
+			name: "pending cleared",
+			rule: Rule{Name: "errors", Expr: "error > 100 for 2m"},
+			steps: []step{
+				{value: 120, want: Pending},
+				{advance: time.Minute, value: 90, want: Inactive},
+				{advance: time.Minute, value: 120, want: Pending},
+				{advance: time.Minute, value: 120, want: Pending},
+			},
+		},
+		{
+			name: "hysteresis",
+			rule: Rule{Name: "errors", Expr: "error > 100", Clear: "80"},
+			steps: []step{
+				{value: 120, want: Firing},
+				// Below the threshold, but not the clear threshold
+				{advance: time.Minute, value: 90, want: Firing},
+				{advance: time.Minute, value: 110, want: Firing},
+				{advance: time.Minute, value: 70, want: Resolved},
+			},
+			want: []string{"errors firing", "errors resolved"},
+		},
+		{
+			name: "keep firing",
+			rule: Rule{Name: "errors", Expr: "error > 100", KeepFiring: 2 * time.Minute},
+			steps: []step{
+				{value: 120, want: Firing},
+				{advance: time.Minute, value: 90, want: Firing},
+				{advance: time.Minute, value: 90, want: Firing},
+				// The value went back above, the clear period restarts
+				{advance: time.Minute, value: 110, want: Firing},
+				{advance: time.Minute, value: 90, want: Firing},
+				{advance: 2 * time.Minute, value: 90, want: Resolved},
+			},
+			want: []string{"errors firing", "errors resolved"},
+		},
+		{
+			name: "rate with a reset",
+			rule: Rule{Name: "errors", Expr: "rate(error) > 1/s"},
+			steps: []step{
+				// No rate before the second sample
+				{value: 0, want: Inactive},
+				{advance: 10 * time.Second, value: 5, want: Inactive},
+				{advance: 10 * time.Second, value: 30, want: Firing},
+				// Reset: the whole value is the increase
+				{advance: 10 * time.Second, value: 4, want: Resolved},
+				{advance: 10 * time.Second, value: 24, want: Firing},
+			},
+			want: []string{"errors firing", "errors resolved", "errors firing"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			te := newTestEngine(map[string]interface{}{"error": 0}, nil)
+			if err := te.AddRule(test.rule); err != nil {
+				t.Fatalf("AddRule() error = %v", err)
+			}
+			te.run(t, "error", test.steps)
+			if strings.Join(te.notifications, ", ") != strings.Join(test.want, ", ") {
+				t.Errorf("notifications = %q, want %q", te.notifications, test.want)
+			}
+		})
+	}
+}
+
+func TestEngineHistogramUnits(t *testing.T) {
+	tests := []struct {
+		name string
+		unit string
+		expr string
+		// Reason AddRule rejects the rule with, if any
+		wantErr string
+		want    State
+	}{
+		{name: "own unit", expr: "p99(latency) >= 0.5", want: Firing},
+		{name: "seconds", unit: "seconds", expr: "p99(latency) >= 500ms", want: Firing},
+		{name: "seconds below", unit: "s", expr: "p99(latency) >= 5s", want: Inactive},
+		{name: "milliseconds", unit: "ms", expr: "avg(latency) > 2ms", want: Firing},
+		{name: "count", expr: "count(latency) == 100", want: Firing},
+		{name: "no unit", expr: "p99(latency) >= 500ms", wantErr: "need the unit of Histogram metrics"},
+		{name: "other unit", unit: "bytes", expr: "p99(latency) >= 500ms", wantErr: "need the unit"},
+		{name: "min", expr: "min(latency) > 1", wantErr: "min is not available"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			te := newTestEngine(map[string]interface{}{}, nil)
+			te.metrics.AddHistogram("latency", []float64{0.1, 0.5, 1, 5})
+			te.metrics.Describe("latency", "Request latency", test.unit)
+			for i := 0; i < 100; i++ {
+				_ = te.metrics.ObserveMetric("latency", 3)
+			}
+
+			err := te.AddRule(Rule{Name: "latency", Expr: test.expr})
+			if test.wantErr != "" {
+				var ruleErr errWrap.RuleInvalid
+				if !errors.As(err, &ruleErr) || !strings.Contains(ruleErr.Reason, test.wantErr) {
+					t.Fatalf("AddRule() error = %v, want a RuleInvalid error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AddRule() error = %v", err)
+			}
+			if err := te.Evaluate(); err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+			if got := te.GetAlerts()[0].State; got != test.want {
+				t.Errorf("state = %s (value %g), want %s", got, te.GetAlerts()[0].Value, test.want)
+			}
+		})
+	}
+}
+
+func TestEngineHistogramUnitAddedLater(t *testing.T) {
+	te := newTestEngine(map[string]interface{}{}, nil)
+	// The metric does not exist yet, the unit is checked when evaluating
+	if err := te.AddRule(Rule{Name: "latency", Expr: "p99(latency) >= 500ms"}); err != nil {
+		t.Fatalf("AddRule() error = %v", err)
+	}
+	te.metrics.AddHistogram("latency", []float64{0.1, 0.5, 1, 5})
+	_ = te.metrics.ObserveMetric("latency", 3)
+
+	var ruleErr errWrap.RuleInvalid
+	if err := te.Evaluate(); !errors.As(err, &ruleErr) {
+		t.Fatalf("Evaluate() error = %v, want a RuleInvalid error", err)
+	}
+	if got := te.GetAlerts()[0].State; got != Inactive {
+		t.Errorf("state = %s, want inactive", got)
+	}
+
+	te.metrics.Describe("latency", "", "seconds")
+	if err := te.Evaluate(); err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+	if got := te.GetAlerts()[0].State; got != Firing {
+		t.Errorf("state = %s, want firing", got)
+	}
+}
+
+func TestEngineTelemetry(t *testing.T) {
+	windows := fakeWindows{
+		"main.taskA()":      {{Span: time.Minute, Count: 60, P50: 100, P90: 250, P99: 400}},
+		"main.taskB()":      {{Span: time.Minute, Count: 6, P99: 50}},
+		"other.taskB()":     {{Span: time.Minute, Count: 6, P99: 60}},
+		"main.(*T).taskC()": {{Span: time.Minute, Count: 0}},
+	}
+	tests := []struct {
+		name string
+		expr string
+		// Part of the expected evaluation error, if any
+		wantErr string
+		want    State
+	}{
+		{name: "full name", expr: "telemetry p99(main.taskA()) > 300ms", want: Firing},
+		{name: "short name", expr: "telemetry p90(taskA) > 300ms", want: Inactive},
+		{name: "rate", expr: "telemetry rate(taskA) >= 1/s", want: Firing},
+		{name: "method", expr: "telemetry count(taskC) == 0", want: Firing},
+		{name: "no calls", expr: "telemetry p99(taskC) > 0", want: Inactive},
+		{name: "ambiguous", expr: "telemetry p99(taskB) > 10ms", wantErr: "main.taskB(), other.taskB()"},
+		{name: "missing", expr: "telemetry p99(taskD) > 10ms", wantErr: "was not found"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			te := newTestEngine(map[string]interface{}{}, windows)
+			if err := te.AddRule(Rule{Name: "telemetry", Expr: test.expr}); err != nil {
+				t.Fatalf("AddRule() error = %v", err)
+			}
+			err := te.Evaluate()
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Evaluate() error = %v, want it to contain %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+			if got := te.GetAlerts()[0].State; got != test.want {
+				t.Errorf("state = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package alert
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	gometrics "metrics"
+	errWrap "metrics/error"
+)
+
+// Rule Condition on the metrics that raises an alert
+//
+// The expression compares a value to a threshold, optionally for some time
+// before the alert fires:
+//
+//	error > 100
+//	rate(success) < 5/s for 2m
+//	p99(latency_seconds) >= 0.5
+//	telemetry p99(taskA) > 200ms
+//	age(heartbeat) > 30s
+//
+// The value is a metric (Counter, Fraction, Gauge or the count of a Meter)
+// or a function of one: rate (per second), pNN (percentile), avg, min, max,
+// count or age (of a Time metric, in milliseconds). The telemetry prefix reads the sliding windows of the traced
+// functions instead (see telemetry.SetWindows).
+//
+// Thresholds are numbers, percentages (5%), rates (5/s, 100/m, 1000/h) or
+// durations, converted to milliseconds as the latencies of the telemetry
+// windows, Timer metrics and ages. The values of Histogram and Window
+// metrics are in their own unit, so duration thresholds need it to be set
+// with Describe (ex. "seconds") to convert the values to milliseconds.
+type Rule struct {
+	// Name Name of the alert, unique in an Engine
+	Name string
+	// Expr Condition of the alert
+	Expr string
+	// Clear Threshold the value must cross back for a firing alert to
+	// resolve (hysteresis), ex. "80" for "error > 100", the threshold of
+	// Expr if empty
+	Clear string
+	// KeepFiring How long the value must stay cleared before a firing alert
+	// resolves
+	KeepFiring time.Duration
+	// Summary Description of the alert for the notifications
+	Summary string
+	// Labels Labels of the notifications, ex. severity
+	Labels map[string]string
+}
+
+// condition Parsed expression of a rule
+type condition struct {
+	// telemetry Whether the value is read from the windows of the traced
+	// functions
+	telemetry bool
+	// function Function of the value, empty for the value of the metric
+	function string
+	// percentile Percentile of the pNN functions, in [0, 1]
+	percentile float64
+	name       string
+	operator   string
+	threshold  float64
+	clear      float64
+	duration   time.Duration
+	// durations Whether the thresholds are durations, in milliseconds
+	durations bool
+}
+
+var (
+	functionRegexp   = regexp.MustCompile(`^[a-z][a-z0-9.]*$`)
+	percentileRegexp = regexp.MustCompile(`^p([0-9]+(\.[0-9]+)?)$`)
+	// Operators, the two characters ones first
+	operators = []string{">=", "<=", "==", "!=", ">", "<"}
+	// Units of the rate thresholds, in seconds
+	rateUnits = map[string]float64{"s": 1, "m": 60, "h": 3600}
+	// Units of the Histogram and Window metrics the duration thresholds can
+	// be converted to, as set with Describe
+	durationUnits = map[string]time.Duration{
+		"ns": time.Nanosecond, "nanoseconds": time.Nanosecond,
+		"us": time.Microsecond, "microseconds": time.Microsecond,
+		"ms": time.Millisecond, "milliseconds": time.Millisecond,
+		"s": time.Second, "seconds": time.Second,
+		"m": time.Minute, "minutes": time.Minute,
+		"h": time.Hour, "hours": time.Hour,
+	}
+)
+
+// parseRule Parse the expression and clear threshold of a rule
+func parseRule(rule Rule) (condition, error) {
+	invalid := func(reason string, args ...interface{}) (condition, error) {
+		return condition{}, errWrap.RuleInvalid{Rule: rule.Name, Expr: rule.Expr, Reason: fmt.Sprintf(reason, args...)}
+	}
+	if rule.Name == "" {
+		return invalid("missing name")
+	}
+
+	var c condition
+	expr := strings.TrimSpace(rule.Expr)
+	if rest, ok := strings.CutPrefix(expr, "telemetry "); ok {
+		c.telemetry = true
+		expr = strings.TrimSpace(rest)
+	}
+
+	position := findOperator(expr)
+	if position < 0 {
+		return invalid("missing comparison operator, expected one of %s", strings.Join(operators, " "))
+	}
+	for _, operator := range operators {
+		if strings.HasPrefix(expr[position:], operator) {
+			c.operator = operator
+			break
+		}
+	}
+
+	// Value: name or function(name)
+	value := strings.TrimSpace(expr[:position])
+	if open := strings.IndexByte(value, '('); open > 0 && strings.HasSuffix(value, ")") {
+		c.function = value[:open]
+		c.name = strings.TrimSpace(value[open+1 : len(value)-1])
+		if !functionRegexp.MatchString(c.function) {
+			return invalid("invalid function %q", c.function)
+		}
+		if match := percentileRegexp.FindStringSubmatch(c.function); match != nil {
+			percentile, _ := strconv.ParseFloat(match[1], 64)
+			if percentile <= 0 || percentile > 100 {
+				return invalid("percentile %s is not in ]0, 100]", match[1])
+			}
+			c.percentile = percentile / 100
+		} else {
+			switch c.function {
+			case "rate", "avg", "min", "max", "count", "age":
+			default:
+				return invalid("unknown function %q, expected rate, pNN, avg, min, max, count or age", c.function)
+			}
+		}
+	} else {
+		c.name = value
+	}
+	// Label values (ex. `requests{path="/a b"}`) can have spaces
+	if base, _, _ := strings.Cut(c.name, "{"); base == "" || strings.ContainsAny(base, " \t") {
+		return invalid("invalid metric name %q", c.name)
+	}
+	if c.telemetry && c.function == "" {
+		return invalid("telemetry values need a function, ex. p99(%s)", c.name)
+	}
+	if c.telemetry && c.percentile > 0 && !isWindowPercentile(c.percentile) {
+		return invalid("telemetry windows only have the p50, p90 and p99 percentiles")
+	}
+
+	// Threshold and duration: "5/s for 2m"
+	fields := strings.Fields(expr[position+len(c.operator):])
+	switch {
+	case len(fields) == 1:
+	case len(fields) == 3 && fields[1] == "for":
+		duration, err := time.ParseDuration(fields[2])
+		if err != nil || duration < 0 {
+			return invalid("invalid duration %q", fields[2])
+		}
+		c.duration = duration
+	case len(fields) == 0:
+		return invalid("missing threshold")
+	default:
+		return invalid("unexpected %q after the threshold, expected \"for <duration>\"", strings.Join(fields[1:], " "))
+	}
+	threshold, durations, err := parseThreshold(fields[0])
+	if err != nil {
+		return invalid("invalid threshold %q", fields[0])
+	}
+	c.threshold = threshold
+	c.durations = durations
+
+	// The clear threshold must be on the side of the values that do not
+	// fire, or the alert would never resolve
+	c.clear = c.threshold
+	if rule.Clear != "" {
+		if c.clear, durations, err = parseThreshold(rule.Clear); err != nil {
+			return invalid("invalid clear threshold %q", rule.Clear)
+		}
+		if durations != c.durations {
+			return invalid("clear threshold %s is not a duration like the threshold, or the other way round", rule.Clear)
+		}
+		switch c.operator {
+		case ">", ">=":
+			if c.clear > c.threshold {
+				return invalid("clear threshold %s is above the threshold", rule.Clear)
+			}
+		case "<", "<=":
+			if c.clear < c.threshold {
+				return invalid("clear threshold %s is below the threshold", rule.Clear)
+			}
+		default:
+			return invalid("%s conditions can not have a clear threshold", c.operator)
+		}
+	}
+	if rule.KeepFiring < 0 {
+		return invalid("negative KeepFiring")
+	}
+	return c, nil
+}
+
+// checkFunction Check that the function of a condition applies to the type
+// of its metric, when it is known
+//
+// metricType Type of the metric
+// unit Unit of the metric, set with Describe
+// returns the reason the function does not apply, empty if it does
+func checkFunction(c condition, metricType gometrics.MetricType, unit string) string {
+	typeName := ""
+	switch metricType {
+	case gometrics.Histogram:
+		// Only the bucket counts are known, not the values observed
+		switch c.function {
+		case "min", "max", "rate":
+			return fmt.Sprintf("%s is not available for Histogram metrics", c.function)
+		}
+		typeName = "Histogram"
+	case gometrics.Window:
+		if c.percentile > 0 && !isWindowPercentile(c.percentile) {
+			return "Window metrics only have the p50, p90 and p99 percentiles"
+		}
+		typeName = "Window"
+	default:
+		return ""
+	}
+
+	if _, known := durationUnits[unit]; c.durations && isDurationFunction(c) && !known {
+		return fmt.Sprintf("duration thresholds need the unit of %s metrics, set with Describe (ex. seconds)", typeName)
+	}
+	return ""
+}
+
+// isDurationFunction Whether the function of a condition on a Histogram or
+// Window metric is in the unit of the metric, unlike count and rate
+func isDurationFunction(c condition) bool {
+	return c.function != "count" && c.function != "rate"
+}
+
+// isWindowPercentile Whether a percentile is one of the percentiles of the
+// windows
+func isWindowPercentile(percentile float64) bool {
+	return percentile == 0.5 || percentile == 0.9 || percentile == 0.99
+}
+
+// findOperator Position of the comparison operator of an expression,
+// outside of parentheses, label braces and quotes
+//
+// returns -1 if there is none
+func findOperator(expr string) int {
+	depth := 0
+	quoted := false
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case quoted:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				quoted = false
+			}
+		case c == '"':
+			quoted = true
+		case c == '(' || c == '{':
+			depth++
+		case c == ')' || c == '}':
+			depth--
+		case depth == 0:
+			for _, operator := range operators {
+				if strings.HasPrefix(expr[i:], operator) {
+					return i
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// parseThreshold Parse a threshold: a number, a percentage (5%), a rate
+// (5/s) or a duration (200ms), in milliseconds
+//
+// returns the threshold and whether it is a duration
+func parseThreshold(text string) (float64, bool, error) {
+	if number, ok := strings.CutSuffix(text, "%"); ok {
+		value, err := strconv.ParseFloat(number, 64)
+		return value / 100, false, err
+	}
+	if number, unit, ok := strings.Cut(text, "/"); ok {
+		seconds, known := rateUnits[unit]
+		if !known {
+			return 0, false, fmt.Errorf("unknown rate unit %q", unit)
+		}
+		value, err := strconv.ParseFloat(number, 64)
+		return value / seconds, false, err
+	}
+	if value, err := strconv.ParseFloat(text, 64); err == nil {
+		return value, false, nil
+	}
+	duration, err := time.ParseDuration(text)
+	if err != nil {
+		return 0, false, err
+	}
+	return float64(duration) / float64(time.Millisecond), true, nil
+}
+
+// compare Compare a value to a threshold
+func compare(value float64, operator string, threshold float64) bool {
+	switch operator {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case "==":
+		return value == threshold
+	default:
+		return value != threshold
+	}
+}
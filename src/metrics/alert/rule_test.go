@@ -0,0 +1,160 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package alert
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	errWrap "metrics/error"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		want condition
+	}{
+		{
+			name: "metric",
+			rule: Rule{Name: "errors", Expr: "error > 100"},
+			want: condition{name: "error", operator: ">", threshold: 100, clear: 100},
+		},
+		{
+			name: "rate for a duration",
+			rule: Rule{Name: "success", Expr: "rate(success) < 5/m for 2m"},
+			want: condition{function: "rate", name: "success", operator: "<", threshold: 5.0 / 60, clear: 5.0 / 60,
+				duration: 2 * time.Minute},
+		},
+		{
+			name: "percentile with a clear threshold",
+			rule: Rule{Name: "latency", Expr: "p99.5(latency) >= 500ms", Clear: "400ms"},
+			want: condition{function: "p99.5", percentile: 0.995, name: "latency", operator: ">=", threshold: 500,
+				clear: 400, durations: true},
+		},
+		{
+			name: "telemetry",
+			rule: Rule{Name: "taskA", Expr: "telemetry p90(taskA) > 2s"},
+			want: condition{telemetry: true, function: "p90", percentile: 0.9, name: "taskA", operator: ">",
+				threshold: 2000, clear: 2000, durations: true},
+		},
+		{
+			name: "labeled series",
+			rule: Rule{Name: "path", Expr: `requests{path="a > b"} != 0`},
+			want: condition{name: `requests{path="a > b"}`, operator: "!="},
+		},
+		{
+			name: "percentage",
+			rule: Rule{Name: "cache", Expr: "cache <= 90%"},
+			want: condition{name: "cache", operator: "<=", threshold: 0.9, clear: 0.9},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseRule(test.rule)
+			if err != nil {
+				t.Fatalf("parseRule() error = %v", err)
+			}
+			if got != test.want {
+				t.Errorf("parseRule() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseRuleInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		// Part of the expected reason
+		want string
+	}{
+		{name: "no name", rule: Rule{Expr: "error > 1"}, want: "missing name"},
+		{name: "no operator", rule: Rule{Name: "a", Expr: "error"}, want: "missing comparison operator"},
+		{name: "no threshold", rule: Rule{Name: "a", Expr: "error >"}, want: "missing threshold"},
+		{name: "bad threshold", rule: Rule{Name: "a", Expr: "error > lots"}, want: "invalid threshold"},
+		{name: "bad rate unit", rule: Rule{Name: "a", Expr: "rate(error) > 5/d"}, want: "invalid threshold"},
+		{name: "bad duration", rule: Rule{Name: "a", Expr: "error > 1 for ever"}, want: "invalid duration"},
+		{name: "trailing text", rule: Rule{Name: "a", Expr: "error > 1 during 2m"}, want: "unexpected"},
+		{name: "unknown function", rule: Rule{Name: "a", Expr: "sum(error) > 1"}, want: "unknown function"},
+		{name: "percentile", rule: Rule{Name: "a", Expr: "p0(latency) > 1"}, want: "is not in ]0, 100]"},
+		{name: "telemetry value", rule: Rule{Name: "a", Expr: "telemetry taskA > 1"}, want: "need a function"},
+		{
+			name: "telemetry percentile",
+			rule: Rule{Name: "a", Expr: "telemetry p95(taskA) > 1"},
+			want: "only have the p50, p90 and p99",
+		},
+		{
+			name: "clear above",
+			rule: Rule{Name: "a", Expr: "error > 100", Clear: "120"},
+			want: "is above the threshold",
+		},
+		{
+			name: "clear below",
+			rule: Rule{Name: "a", Expr: "error < 100", Clear: "80"},
+			want: "is below the threshold",
+		},
+		{
+			name: "clear equality",
+			rule: Rule{Name: "a", Expr: "error == 100", Clear: "80"},
+			want: "can not have a clear threshold",
+		},
+		{
+			name: "clear not a duration",
+			rule: Rule{Name: "a", Expr: "p99(latency) > 500ms", Clear: "400"},
+			want: "is not a duration",
+		},
+		{
+			name: "negative keep firing",
+			rule: Rule{Name: "a", Expr: "error > 1", KeepFiring: -time.Second},
+			want: "negative KeepFiring",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseRule(test.rule)
+			var ruleErr errWrap.RuleInvalid
+			if !errors.As(err, &ruleErr) {
+				t.Fatalf("parseRule() error = %v, want a RuleInvalid error", err)
+			}
+			if !strings.Contains(ruleErr.Reason, test.want) {
+				t.Errorf("parseRule() reason = %q, want it to contain %q", ruleErr.Reason, test.want)
+			}
+		})
+	}
+}
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		text         string
+		want         float64
+		wantDuration bool
+	}{
+		{text: "100", want: 100},
+		{text: "-2.5", want: -2.5},
+		{text: "5%", want: 0.05},
+		{text: "5/s", want: 5},
+		{text: "120/m", want: 2},
+		{text: "3600/h", want: 1},
+		{text: "200ms", want: 200, wantDuration: true},
+		{text: "1.5s", want: 1500, wantDuration: true},
+		{text: "1m", want: 60000, wantDuration: true},
+	}
+	for _, test := range tests {
+		got, duration, err := parseThreshold(test.text)
+		if err != nil || got != test.want || duration != test.wantDuration {
+			t.Errorf("parseThreshold(%q) = %v, %v, %v, want %v, %v, nil", test.text, got, duration, err, test.want,
+				test.wantDuration)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Sink Destination of the alerts that fire and resolve
+type Sink interface {
+	Notify(alert Alert) error
+}
+
+// SinkFunc Callback sink
+type SinkFunc func(alert Alert) error
+
+// Notify calls the function
+func (f SinkFunc) Notify(alert Alert) error {
+	return f(alert)
+}
+
+// logSink Sink writing to a logger
+type logSink struct {
+	logger *log.Logger
+}
+
+// LogSink returns a sink logging the alerts, ex.
+// "alert errors firing: error > 100 (value 120)"
+//
+// logger Logger to write to, the standard logger if nil
+func LogSink(logger *log.Logger) Sink {
+	return logSink{logger: logger}
+}
+
+// Notify logs the alert
+func (s logSink) Notify(alert Alert) error {
+	message := fmt.Sprintf("alert %s %s: %s (value %g)", alert.Rule, alert.State, alert.Expr, alert.Value)
+	if alert.Summary != "" {
+		message += " " + alert.Summary
+	}
+	if s.logger == nil {
+		log.Print(message)
+	} else {
+		s.logger.Print(message)
+	}
+	return nil
+}
+
+// webhookSink Sink posting to a URL
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// WebhookSink returns a sink posting the alerts as JSON to a URL
+//
+// url URL of the webhook
+// client Client used for the requests, one with a 10s timeout if nil
+func WebhookSink(url string, client *http.Client) Sink {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return webhookSink{url: url, client: client}
+}
+
+// Notify posts the alert, fails if the response is not a 2xx
+func (s webhookSink) Notify(alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	response, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("webhook %s: %s %s", s.url, response.Status, strings.TrimSpace(string(message)))
+	}
+	_, _ = io.Copy(io.Discard, response.Body)
+	return nil
+}
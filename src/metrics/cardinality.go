@@ -0,0 +1,201 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package metrics
+
+import (
+	"log"
+	"sync/atomic"
+
+	errWrap "metrics/error"
+	metricTypes "metrics/metrictypes"
+)
+
+// OverflowLabel Label of the series the new series of a metric are folded
+// into once a limit is reached, ex. `requests{overflow="true"}`
+const OverflowLabel = "overflow"
+
+// RejectedSeriesMetric Counter of the series folded into an overflow series,
+// added by SetCardinalityLimits
+const RejectedSeriesMetric = "metrics_rejected_series"
+
+// CardinalityLimits Maximum number of series created at runtime by AddMetric,
+// AddSeries and Time, so unbounded names or label values (ex. user IDs)
+// can not exhaust the memory
+//
+// Series beyond a limit are folded into the overflow series of their metric,
+// of the type of the first series folded into it, so the types of the
+// metrics are kept. Past MaxSeries, the series of metrics that have no
+// series yet are rejected. The metrics of NewMetrics and of the other Add
+// methods are counted but never rejected.
+type CardinalityLimits struct {
+	// MaxSeries Maximum number of series of the Metrics, 0 for no limit
+	MaxSeries int
+	// MaxSeriesPerMetric Maximum number of series of every metric (ex. of
+	// `requests{...}`), 0 for no limit
+	MaxSeriesPerMetric int
+	// PerMetric Maximum number of series of some metrics, by metric name,
+	// overriding MaxSeriesPerMetric
+	PerMetric map[string]int
+	// OnOverflow Called with a CardinalityExceeded error when an overflow
+	// series is created, or when MaxSeries starts rejecting series, the
+	// error is logged with the standard logger if nil
+	OnOverflow func(err error)
+}
+
+// SetCardinalityLimits sets the limits of the number of series created at
+// runtime and adds the RejectedSeriesMetric counter
+//
+// The series that already exist are kept, even beyond the limits.
+//
+// limits Limits of the series, CardinalityLimits{} to remove them
+func (metric Metrics) SetCardinalityLimits(limits CardinalityLimits) {
+	perMetric := make(map[string]int, len(limits.PerMetric))
+	for metricName, limit := range limits.PerMetric {
+		perMetric[metricName] = limit
+	}
+	limits.PerMetric = perMetric
+
+	metric.mutex.Lock()
+	metric.options.limits = limits
+	metric.mutex.Unlock()
+
+	metric.AddCounterFunc(RejectedSeriesMetric, func() int {
+		return int(metric.RejectedSeries())
+	})
+}
+
+// RejectedSeries returns the number of times a series was folded into an
+// overflow series or rejected
+func (metric Metrics) RejectedSeries() uint64 {
+	return atomic.LoadUint64(&metric.options.rejectedSeries)
+}
+
+// AddSeries adds the series of a metric with the given labels unless it
+// already exists, ex. `requests{method="GET"}`
+//
+// The series beyond the cardinality limits are folded into an overflow
+// series, so the returned name must be used to update the series.
+//
+// metricName Name of the metric
+// labels Label names and values, the metric itself is added if empty
+// initialValue Initial value of the series, its type sets the metric type
+// returns the name of the series, or of the overflow series. The updates of
+// a rejected series fail with MetricNotFound since it was not added.
+func (metric Metrics) AddSeries(metricName string, labels map[string]string, initialValue interface{}) string {
+	metric.mutex.Lock()
+	series, _, err := metric.addSeries(SeriesName(metricName, labels), initialValue)
+	metric.mutex.Unlock()
+
+	metric.warnOverflow(err)
+	return series
+}
+
+// addSeries Add a series unless it already exists, folding it into an
+// overflow series beyond the cardinality limits, the lock must be held
+//
+// returns the name of the series added or updated, whether the given series
+// was added, and a CardinalityExceeded error if an overflow series was
+// created or MaxSeries started rejecting series
+func (metric Metrics) addSeries(metricName string, initialValue interface{}) (string, bool, error) {
+	series, limit := metric.admit(metricName)
+	if series == "" {
+		// Reported once until the number of series goes below MaxSeries
+		atomic.AddUint64(&metric.options.rejectedSeries, 1)
+		if metric.options.rejecting {
+			return metricName, false, nil
+		}
+		metric.options.rejecting = true
+		return metricName, false, errWrap.CardinalityExceeded{MetricName: metricName, Limit: limit}
+	}
+
+	added := false
+	metric.change(series, func() error {
+		added = metric.metricData.AddMissingMetric(series, initialValue)
+		return nil
+	})
+	if series == metricName {
+		return series, added, nil
+	}
+
+	atomic.AddUint64(&metric.options.rejectedSeries, 1)
+	if !added {
+		return series, false, nil
+	}
+	return series, false, errWrap.CardinalityExceeded{MetricName: metricName, Limit: limit, Overflow: series}
+}
+
+// admit Name of the series to add for a series under the cardinality
+// limits, the lock must be held
+//
+// returns the series itself, or the overflow series (empty if it is
+// rejected) and the limit reached
+func (metric Metrics) admit(metricName string) (string, int) {
+	limits := metric.options.limits
+	if limits.MaxSeries <= 0 && limits.MaxSeriesPerMetric <= 0 && len(limits.PerMetric) == 0 {
+		return metricName, 0
+	}
+
+	total, family, exists := metric.metricData.CountSeries(metricName)
+	if exists {
+		return metricName, 0
+	}
+	if limits.MaxSeries <= 0 || total < limits.MaxSeries {
+		metric.options.rejecting = false
+	}
+
+	familyName := metricTypes.FamilyName(metricName)
+	familyLimit, ok := limits.PerMetric[familyName]
+	if !ok {
+		familyLimit = limits.MaxSeriesPerMetric
+	}
+	overflow := SeriesName(familyName, map[string]string{OverflowLabel: "true"})
+	if metricName == overflow && family > 0 {
+		// Overflow series are bounded by the number of metrics
+		return metricName, 0
+	}
+	seriesCount := family
+	if familyLimit > 0 && family >= familyLimit {
+		// The overflow series does not count towards the limit
+		if _, _, overflowExists := metric.metricData.CountSeries(overflow); overflowExists {
+			seriesCount--
+		}
+	}
+	switch {
+	case familyLimit > 0 && seriesCount >= familyLimit:
+		return overflow, familyLimit
+	case limits.MaxSeries > 0 && total >= limits.MaxSeries && family > 0:
+		// Folded with the series of the same metric, of the same type
+		return overflow, limits.MaxSeries
+	case limits.MaxSeries > 0 && total >= limits.MaxSeries:
+		// An overflow series per new metric would not bound anything
+		return "", limits.MaxSeries
+	default:
+		return metricName, 0
+	}
+}
+
+// warnOverflow Report the creation of an overflow series, called without
+// holding the lock
+func (metric Metrics) warnOverflow(err error) {
+	if err == nil {
+		return
+	}
+
+	metric.mutex.Lock()
+	onOverflow := metric.options.limits.OnOverflow
+	metric.mutex.Unlock()
+
+	if onOverflow == nil {
+		log.Print(err)
+		return
+	}
+	onOverflow(err)
+}
@@ -0,0 +1,373 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	errWrap "metrics/error"
+)
+
+// Version of the checkpoint file format
+const checkpointVersion = 1
+
+// First word of the header line of checkpoint files
+const checkpointMagic = "METRICS-CHECKPOINT"
+
+// Table of the checksum of the checkpoint files
+var checkpointTable = crc32.MakeTable(crc32.Castagnoli)
+
+// CheckpointOptions Settings of the checkpointing of a Metrics
+type CheckpointOptions struct {
+	// Path Path of the checkpoint file
+	Path string
+	// Interval Time between checkpoints, 0 to only write them on Close (or
+	// Checkpoint)
+	Interval time.Duration
+	// Exclude Names of the metrics that are neither saved nor restored
+	Exclude []string
+	// OnError Called with the errors of the checkpoints written every
+	// Interval, they are ignored if nil
+	OnError func(error)
+}
+
+// checkpointPayload Contents of a checkpoint file after its header
+type checkpointPayload struct {
+	Time    time.Time
+	Metrics map[string]checkpointMetric
+}
+
+// checkpointMetric One metric of a checkpoint file
+type checkpointMetric struct {
+	Type  string
+	Value json.RawMessage
+}
+
+// checkpointer Writes the checkpoints of a Metrics
+type checkpointer struct {
+	// Serializes the writes of the checkpoint file
+	sync.Mutex
+	options CheckpointOptions
+	exclude map[string]bool
+	stop    chan struct{}
+	done    chan struct{}
+	closed  bool
+}
+
+// NewMetricsWithCheckpoint returns a new Metrics, as NewMetrics, whose values
+// persist across restarts
+//
+// The values of Counter, Fraction, Gauge, String and Time metrics are
+// restored from the checkpoint file (if any) and saved back on every
+// Interval and on Close. The file is written atomically (to a temporary file
+// renamed over it) and is checksummed.
+//
+// Only the metrics declared in both the file and the Metrics with the same
+// type are restored, so metrics can be added, removed or change types
+// between versions. Computed metrics (callbacks, Meter, Window, Timer and
+// Ratio) are not saved.
+//
+// metrics Map that contains the name and type of the metrics to be added
+// options Checkpoint settings
+// returns an error wrapping CheckpointInvalid if the file is corrupt or can
+// not be restored, the Metrics is usable anyway (with the initial values)
+func NewMetricsWithCheckpoint(metrics map[string]interface{}, options CheckpointOptions) (Metrics, error) {
+	metric := NewMetrics(metrics)
+
+	checkpoint := &checkpointer{
+		options: options,
+		exclude: map[string]bool{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for _, metricName := range options.Exclude {
+		checkpoint.exclude[metricName] = true
+	}
+	metric.options.checkpoint = checkpoint
+
+	err := metric.restoreCheckpoint()
+
+	if options.Interval > 0 {
+		go metric.runCheckpoints()
+	} else {
+		close(checkpoint.done)
+	}
+	return metric, err
+}
+
+// runCheckpoints Write a checkpoint on every interval until Close
+func (metric Metrics) runCheckpoints() {
+	checkpoint := metric.options.checkpoint
+	defer close(checkpoint.done)
+
+	ticker := time.NewTicker(checkpoint.options.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// The next checkpoint or Close will try again
+			if err := metric.Checkpoint(); err != nil && checkpoint.options.OnError != nil {
+				checkpoint.options.OnError(err)
+			}
+		case <-checkpoint.stop:
+			return
+		}
+	}
+}
+
+// Checkpoint writes the checkpoint file now
+//
+// A metric whose value can not be encoded is left out, the others are
+// written anyway
+//
+// returns error if the Metrics has no checkpointing, the file can not be
+// written or a metric was left out (the first one)
+func (metric Metrics) Checkpoint() error {
+	checkpoint := metric.getCheckpointer()
+	if checkpoint == nil {
+		return fmt.Errorf("Unable to checkpoint metrics: checkpointing is not enabled")
+	}
+
+	snapshot := metric.Snapshot()
+	payload := checkpointPayload{
+		Time:    snapshot.Time(),
+		Metrics: map[string]checkpointMetric{},
+	}
+	// A bad metric does not prevent saving the others
+	var metricErr error
+	for _, metricName := range snapshot.GetMetricNames() {
+		value, _ := snapshot.ReadMetric(metricName)
+		if value == nil || checkpoint.exclude[metricName] || metric.metricData.IsComputedMetric(metricName) {
+			continue
+		}
+		metricType := snapshot.GetMetricType(metricName)
+		switch metricType {
+		case Counter, Fraction, Gauge, String, Time:
+		default:
+			continue
+		}
+
+		data, err := encodeCheckpointValue(value)
+		if err != nil {
+			if metricErr == nil {
+				metricErr = fmt.Errorf("Unable to checkpoint metric |name=%s|: %w", metricName, err)
+			}
+			continue
+		}
+		payload.Metrics[metricName] = checkpointMetric{
+			Type:  getMetricTypeName(metricType),
+			Value: data,
+		}
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("Unable to checkpoint metrics: %w", err)
+	}
+
+	checkpoint.Lock()
+	defer checkpoint.Unlock()
+	if err := writeCheckpointFile(checkpoint.options.Path, data); err != nil {
+		return err
+	}
+	return metricErr
+}
+
+// encodeCheckpointValue Encode the value of a metric
+//
+// JSON has no NaN nor infinities, these float64 values are encoded as the
+// strings parsed back by decodeCheckpointValue
+func encodeCheckpointValue(value interface{}) ([]byte, error) {
+	if number, ok := value.(float64); ok && (math.IsNaN(number) || math.IsInf(number, 0)) {
+		return json.Marshal(strconv.FormatFloat(number, 'g', -1, 64))
+	}
+	return json.Marshal(value)
+}
+
+// Close stops the periodic checkpoints and writes a last one
+//
+// It does nothing for Metrics without checkpointing. Calling it more than
+// once only writes the checkpoint again.
+func (metric Metrics) Close() error {
+	checkpoint := metric.getCheckpointer()
+	if checkpoint == nil {
+		return nil
+	}
+
+	checkpoint.Lock()
+	if !checkpoint.closed {
+		checkpoint.closed = true
+		close(checkpoint.stop)
+	}
+	checkpoint.Unlock()
+	<-checkpoint.done
+
+	return metric.Checkpoint()
+}
+
+// getCheckpointer Get the checkpointer of the Metrics, nil if it has none
+func (metric Metrics) getCheckpointer() *checkpointer {
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+
+	return metric.options.checkpoint
+}
+
+// writeCheckpointFile Write a checkpoint file atomically
+//
+// The file holds a header line with the format version and the checksum of
+// the payload, followed by the payload
+func writeCheckpointFile(path string, payload []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("Unable to write checkpoint |path=%s|: %w", path, err)
+	}
+	// Removing the temporary file fails once it was renamed
+	defer os.Remove(file.Name())
+
+	header := fmt.Sprintf("%s %d %08x\n", checkpointMagic, checkpointVersion, crc32.Checksum(payload, checkpointTable))
+	writer := bufio.NewWriter(file)
+	writer.WriteString(header)
+	writer.Write(payload)
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("Unable to write checkpoint |path=%s|: %w", path, err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("Unable to write checkpoint |path=%s|: %w", path, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("Unable to write checkpoint |path=%s|: %w", path, err)
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("Unable to write checkpoint |path=%s|: %w", path, err)
+	}
+	return nil
+}
+
+// readCheckpointFile Read and verify a checkpoint file
+//
+// returns nil without error if the file does not exist
+func readCheckpointFile(path string) (*checkpointPayload, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read checkpoint |path=%s|: %w", path, err)
+	}
+
+	headerEnd := bytes.IndexByte(data, '\n')
+	if headerEnd < 0 {
+		return nil, errWrap.CheckpointInvalid{Path: path, Reason: "missing header", Cause: io.ErrUnexpectedEOF}
+	}
+	var magic string
+	var version int
+	var checksum uint32
+	if _, err := fmt.Sscanf(string(data[:headerEnd]), "%s %d %x", &magic, &version, &checksum); err != nil ||
+		magic != checkpointMagic {
+		return nil, errWrap.CheckpointInvalid{Path: path, Reason: "invalid header", Cause: err}
+	}
+	if version != checkpointVersion {
+		return nil, errWrap.CheckpointInvalid{Path: path, Reason: fmt.Sprintf("unsupported version %d", version)}
+	}
+
+	payload := data[headerEnd+1:]
+	if crc32.Checksum(payload, checkpointTable) != checksum {
+		return nil, errWrap.CheckpointInvalid{Path: path, Reason: "checksum mismatch"}
+	}
+
+	checkpoint := &checkpointPayload{}
+	if err := json.Unmarshal(payload, checkpoint); err != nil {
+		return nil, errWrap.CheckpointInvalid{Path: path, Reason: "invalid payload", Cause: err}
+	}
+	return checkpoint, nil
+}
+
+// restoreCheckpoint Restore the values of the metrics from the checkpoint
+// file, if any
+//
+// returns the error of the first metric that could not be restored
+func (metric Metrics) restoreCheckpoint() error {
+	checkpoint := metric.options.checkpoint
+	payload, err := readCheckpointFile(checkpoint.options.Path)
+	if err != nil || payload == nil {
+		return err
+	}
+
+	// A bad metric does not prevent restoring the others
+	var restoreErr error
+	for metricName, saved := range payload.Metrics {
+		// Metrics removed, excluded or whose type changed are skipped
+		if checkpoint.exclude[metricName] || metric.metricData.GetMetricType(metricName) != saved.Type ||
+			metric.metricData.IsComputedMetric(metricName) {
+			continue
+		}
+
+		value, err := decodeCheckpointValue(saved)
+		if err == nil {
+			err = metric.metricData.SetMetricValue(metricName, value)
+		}
+		if err != nil && restoreErr == nil {
+			restoreErr = errWrap.CheckpointInvalid{Path: checkpoint.options.Path, Reason: "invalid metric " + metricName,
+				Cause: err}
+		}
+	}
+	return restoreErr
+}
+
+// decodeCheckpointValue Decode the value of a metric according to its type
+func decodeCheckpointValue(saved checkpointMetric) (interface{}, error) {
+	switch metricCapabilitiesMap[saved.Type] {
+	case Counter:
+		var value int
+		err := json.Unmarshal(saved.Value, &value)
+		return value, err
+
+	case Fraction, Gauge:
+		var value float64
+		err := json.Unmarshal(saved.Value, &value)
+		if err != nil {
+			// NaN and infinities, see encodeCheckpointValue
+			var text string
+			if json.Unmarshal(saved.Value, &text) == nil {
+				return strconv.ParseFloat(text, 64)
+			}
+		}
+		return value, err
+
+	case String:
+		var value string
+		err := json.Unmarshal(saved.Value, &value)
+		return value, err
+
+	case Time:
+		var value time.Time
+		err := json.Unmarshal(saved.Value, &value)
+		return value, err
+
+	default:
+		return nil, fmt.Errorf("unsupported type %s", saved.Type)
+	}
+}
@@ -0,0 +1,77 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock Source of the current time
+//
+// Everything time-based in the metrics packages reads the time through a
+// Clock so tests can replace it with a Manual one
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock Clock backed by time.Now()
+type realClock struct{}
+
+// Now Get the current wall clock time
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Real Clock returning the actual time, used by default
+var Real Clock = realClock{}
+
+// Manual Clock that only moves when told to, meant for tests
+type Manual struct {
+	sync.Mutex
+	now time.Time
+}
+
+// NewManual Create a new Manual clock
+//
+// start Time the clock starts at
+func NewManual(start time.Time) *Manual {
+	return &Manual{
+		Mutex: sync.Mutex{},
+		now:   start,
+	}
+}
+
+// Now Get the current time of the clock
+func (m *Manual) Now() time.Time {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.now
+}
+
+// Set Set the current time of the clock
+func (m *Manual) Set(now time.Time) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.now = now
+}
+
+// Advance Move the clock forward
+//
+// duration Time to add to the clock
+func (m *Manual) Advance(duration time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.now = m.now.Add(duration)
+}
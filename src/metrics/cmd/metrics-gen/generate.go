@@ -0,0 +1,364 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	gometrics "metrics"
+	"metrics/schema"
+)
+
+// initialisms Words written in upper case in Go names
+var initialisms = map[string]bool{
+	"API": true, "CPU": true, "DNS": true, "GC": true, "HTTP": true, "HTTPS": true,
+	"ID": true, "IO": true, "IP": true, "JSON": true, "OS": true, "RPC": true,
+	"SQL": true, "TCP": true, "TLS": true, "TTL": true, "UDP": true, "URI": true,
+	"URL": true, "UUID": true, "XML": true,
+}
+
+// operation Accessor updating a metric, ex. IncX(increment int)
+type operation struct {
+	Prefix  string
+	Verb    string
+	Arg     string
+	ArgType string
+	Call    string
+}
+
+// operations Accessors updating the metrics, by metric type
+var operations = map[string][]operation{
+	schema.Counter: {
+		{"Inc", "increases", "increment", "int", "IncreaseMetricValue"},
+	},
+	schema.Fraction: {
+		{"Add", "increases", "increment", "float64", "IncreaseMetricValue"},
+		{"Sub", "decreases", "decrement", "float64", "DecreaseMetricValue"},
+		{"Set", "sets", "value", "float64", "SetMetric"},
+	},
+	schema.Gauge: {
+		{"Add", "increases", "increment", "float64", "IncreaseMetricValue"},
+		{"Sub", "decreases", "decrement", "float64", "DecreaseMetricValue"},
+		{"Set", "sets", "value", "float64", "SetMetric"},
+	},
+	schema.String: {
+		{"Set", "sets", "value", "string", "SetMetric"},
+	},
+	schema.Time: {
+		{"Set", "sets", "value", "time.Time", "SetMetric"},
+	},
+	schema.Histogram: {
+		{"Observe", "adds a value to", "value", "float64", "ObserveMetric"},
+	},
+}
+
+// readTypes Go type and zero value of the values read, by metric type
+var readTypes = map[string][2]string{
+	schema.Counter:   {"int", "0"},
+	schema.Fraction:  {"float64", "0"},
+	schema.Gauge:     {"float64", "0"},
+	schema.String:    {"string", `""`},
+	schema.Time:      {"time.Time", "time.Time{}"},
+	schema.Histogram: {"gometrics.HistogramValue", "gometrics.HistogramValue{}"},
+}
+
+// parameter Parameter of an accessor holding the value of a label
+type parameter struct {
+	Name  string
+	Label string
+}
+
+// metric Generated code of a metric
+type metric struct {
+	schema.Definition
+	Ident      string
+	Var        string
+	Literal    string
+	Params     []parameter
+	Operations []operation
+	ReadType   string
+	Zero       string
+}
+
+// ParamList Parameters of the label values, ex. "method string, code string, "
+func (m metric) ParamList() string {
+	list := ""
+	for _, param := range m.Params {
+		list += param.Name + " string, "
+	}
+	return list
+}
+
+// ArgList Label values given to Definition.Series, ex. ", method, code"
+func (m metric) ArgList() string {
+	list := ""
+	for _, param := range m.Params {
+		list += ", " + param.Name
+	}
+	return list
+}
+
+var fileTemplate = template.Must(template.New("file").Parse(`// Code generated by metrics-gen from {{.Source}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- if .UsesTime}}
+	"time"
+{{end}}
+	gometrics "{{.Import}}"
+	errWrap "{{.Import}}/error"
+	"{{.Import}}/schema"
+)
+
+// Names of the metrics
+const (
+{{- range .Metrics}}
+	{{.Ident}}Name = {{printf "%q" .Name}}
+{{- end}}
+)
+
+// Definitions of the metrics
+var (
+{{- range .Metrics}}
+	{{.Var}} = {{.Literal}}
+{{- end}}
+)
+
+// Schema Schema the accessors were generated from
+var Schema = schema.Schema{
+	Metrics: []schema.Definition{
+{{- range .Metrics}}
+		{{.Var}},
+{{- end}}
+	},
+}
+
+// Metrics Metrics of the schema, with typed accessors
+type Metrics struct {
+	gometrics.Metrics
+}
+
+// NewMetrics returns the Metrics of the schema
+func NewMetrics() Metrics {
+	metrics, err := schema.NewMetrics(Schema)
+	if err != nil {
+		// The schema was validated by metrics-gen
+		panic(err)
+	}
+	return Metrics{metrics}
+}
+{{range $m := .Metrics}}{{range .Operations}}
+// {{.Prefix}}{{$m.Ident}} {{.Verb}} the {{$m.Name}} {{$m.Type}}
+{{- with $m.Help}}
+//
+// {{.}}
+{{- end}}
+func (m Metrics) {{.Prefix}}{{$m.Ident}}({{$m.ParamList}}{{.Arg}} {{.ArgType}}) error {
+{{- if $m.Params}}
+	series, err := {{$m.Var}}.Series(m.Metrics{{$m.ArgList}})
+	if err != nil {
+		return err
+	}
+	return m.{{.Call}}(series, {{.Arg}})
+{{- else}}
+	return m.{{.Call}}({{$m.Ident}}Name, {{.Arg}})
+{{- end}}
+}
+{{end}}
+// {{.Ident}} returns the value of the {{.Name}} {{.Type}}
+{{- with .Help}}
+//
+// {{.}}
+{{- end}}
+func (m Metrics) {{.Ident}}({{.ParamList}}) ({{.ReadType}}, error) {
+{{- if .Params}}
+	series, err := {{.Var}}.Series(m.Metrics{{.ArgList}})
+	if err != nil {
+		return {{.Zero}}, err
+	}
+	value, err := m.ReadMetric(series)
+{{- else}}
+	value, err := m.ReadMetric({{.Ident}}Name)
+{{- end}}
+	if err != nil {
+		return {{.Zero}}, err
+	}
+	typed, ok := value.({{.ReadType}})
+	if !ok {
+		return {{.Zero}}, errWrap.ValueAssertionInvalid{Value: value, ExpectedType: "{{.ReadType}}"}
+	}
+	return typed, nil
+}
+{{end}}`))
+
+// generate Generate the Go source of the accessors of a schema
+//
+// metricSchema Validated schema
+// source Name of the schema file, for the header
+// pkg Package of the generated file
+// importPath Import path of the metrics package
+func generate(metricSchema schema.Schema, source string, pkg string, importPath string) ([]byte, error) {
+	if !token.IsIdentifier(pkg) {
+		return nil, fmt.Errorf("metrics-gen: invalid package name %q", pkg)
+	}
+
+	// The accessors must not collide with each other nor with the methods
+	// and fields of the embedded Metrics
+	names := map[string]string{"Metrics": "gometrics.Metrics", "NewMetrics": "NewMetrics", "Schema": "Schema"}
+	metricsType := reflect.TypeOf(gometrics.Metrics{})
+	for i := 0; i < metricsType.NumMethod(); i++ {
+		names[metricsType.Method(i).Name] = "gometrics.Metrics"
+	}
+	declare := func(name string, owner string) error {
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("metrics-gen: %s of %s collides with %s, rename the metric", name, owner, other)
+		}
+		names[name] = owner
+		return nil
+	}
+
+	metrics := []metric{}
+	usesTime := false
+	for _, definition := range metricSchema.Metrics {
+		ident := goName(definition.Name, true)
+		m := metric{
+			Definition: definition,
+			Ident:      ident,
+			Var:        goName(definition.Name, false) + "Definition",
+			Literal:    definitionLiteral(definition),
+			Operations: operations[definition.Type],
+			ReadType:   readTypes[definition.Type][0],
+			Zero:       readTypes[definition.Type][1],
+		}
+		usesTime = usesTime || definition.Type == schema.Time
+
+		if err := declare(ident, definition.Name); err != nil {
+			return nil, err
+		}
+		if err := declare(ident+"Name", definition.Name); err != nil {
+			return nil, err
+		}
+		if err := declare(m.Var, definition.Name); err != nil {
+			return nil, err
+		}
+		for _, op := range m.Operations {
+			if err := declare(op.Prefix+ident, definition.Name); err != nil {
+				return nil, err
+			}
+		}
+
+		// Parameters must not shadow the variables of the accessors
+		params := map[string]bool{"m": true, "series": true, "err": true, "value": true,
+			"typed": true, "ok": true, "increment": true, "decrement": true,
+			"gometrics": true, "errWrap": true, "schema": true}
+		for _, label := range definition.Labels {
+			name := goName(label, false)
+			if params[name] || token.IsKeyword(name) || isPredeclared(name) {
+				name += "Label"
+			}
+			params[name] = true
+			m.Params = append(m.Params, parameter{Name: name, Label: label})
+		}
+		metrics = append(metrics, m)
+	}
+
+	var src bytes.Buffer
+	err := fileTemplate.Execute(&src, map[string]interface{}{
+		"Source":   source,
+		"Package":  pkg,
+		"Import":   importPath,
+		"UsesTime": usesTime,
+		"Metrics":  metrics,
+	})
+	if err != nil {
+		return nil, err
+	}
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("metrics-gen: generated invalid code: %w", err)
+	}
+	return formatted, nil
+}
+
+// definitionLiteral Go literal of a definition
+func definitionLiteral(definition schema.Definition) string {
+	var literal strings.Builder
+	fmt.Fprintf(&literal, "schema.Definition{\nName: %q,\nType: schema.%s,\n", definition.Name, definition.Type)
+	if definition.Help != "" {
+		fmt.Fprintf(&literal, "Help: %q,\n", definition.Help)
+	}
+	if definition.Unit != "" {
+		fmt.Fprintf(&literal, "Unit: %q,\n", definition.Unit)
+	}
+	if len(definition.Labels) > 0 {
+		labels := make([]string, len(definition.Labels))
+		for i, label := range definition.Labels {
+			labels[i] = strconv.Quote(label)
+		}
+		fmt.Fprintf(&literal, "Labels: []string{%s},\n", strings.Join(labels, ", "))
+	}
+	if len(definition.Buckets) > 0 {
+		buckets := make([]string, len(definition.Buckets))
+		for i, bucket := range definition.Buckets {
+			buckets[i] = strconv.FormatFloat(bucket, 'g', -1, 64)
+		}
+		fmt.Fprintf(&literal, "Buckets: []float64{%s},\n", strings.Join(buckets, ", "))
+	}
+	literal.WriteString("}")
+	return literal.String()
+}
+
+// goName Go name of a metric or label name, ex. HTTPRequestsTotal (exported)
+// or httpRequestsTotal for http_requests_total
+func goName(name string, exported bool) string {
+	var ident strings.Builder
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == ':' }) {
+		switch {
+		case ident.Len() == 0 && !exported:
+			ident.WriteString(strings.ToLower(word[:1]) + word[1:])
+			if initialisms[strings.ToUpper(word)] {
+				ident.Reset()
+				ident.WriteString(strings.ToLower(word))
+			}
+		case initialisms[strings.ToUpper(word)]:
+			ident.WriteString(strings.ToUpper(word))
+		default:
+			ident.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+
+	if ident.Len() == 0 || unicode.IsDigit(rune(ident.String()[0])) {
+		if exported {
+			return "Metric" + ident.String()
+		}
+		return "metric" + ident.String()
+	}
+	return ident.String()
+}
+
+// isPredeclared Whether a name is a predeclared Go identifier used by the
+// accessors
+func isPredeclared(name string) bool {
+	switch name {
+	case "string", "int", "float64", "error", "time", "true", "false", "nil", "len":
+		return true
+	}
+	return false
+}
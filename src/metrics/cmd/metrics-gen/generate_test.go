@@ -0,0 +1,88 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"metrics/schema"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestGenerateGolden(t *testing.T) {
+	metricSchema, err := schema.Load(filepath.Join("testdata", "metrics.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := generate(metricSchema, "metrics.yaml", "app", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "metrics_gen.go.golden")
+	if *update {
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("generated code differs from %s (run go test -update to accept it):\n%s", golden, got)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		metrics []schema.Definition
+		pkg     string
+		want    string
+	}{
+		{
+			name:    "invalid package",
+			metrics: []schema.Definition{{Name: "a", Type: schema.Counter}},
+			pkg:     "my-app",
+			want:    `metrics-gen: invalid package name "my-app"`,
+		},
+		{
+			name: "colliding accessors",
+			metrics: []schema.Definition{
+				{Name: "http_requests", Type: schema.Counter},
+				{Name: "http:requests", Type: schema.Counter},
+			},
+			pkg:  "app",
+			want: "metrics-gen: HTTPRequests of http:requests collides with http_requests, rename the metric",
+		},
+		{
+			name:    "method of the Metrics",
+			metrics: []schema.Definition{{Name: "snapshot", Type: schema.Gauge}},
+			pkg:     "app",
+			want:    "metrics-gen: Snapshot of snapshot collides with gometrics.Metrics, rename the metric",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := generate(schema.Schema{Metrics: test.metrics}, "metrics.yaml", test.pkg, "metrics")
+			if err == nil || err.Error() != test.want {
+				t.Fatalf("got error %v, want %q", err, test.want)
+			}
+		})
+	}
+}
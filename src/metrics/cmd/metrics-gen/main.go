@@ -0,0 +1,75 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+// metrics-gen generates typed accessors for the metrics of a schema file
+// (see package metrics/schema), ex. IncHTTPRequestsTotal(method, code, 1)
+// instead of IncreaseMetricValue(`http_requests_total{...}`, 1).
+//
+// Usage:
+//
+//	metrics-gen -schema metrics.yaml [-pkg name] [-o metrics_gen.go]
+//
+// It is meant to be run by go generate, which sets the default package name:
+//
+//	//go:generate metrics-gen -schema metrics.yaml
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"metrics/schema"
+)
+
+var (
+	schemaPath  = flag.String("schema", "", "schema file (.json, .yaml or .yml)")
+	packageName = flag.String("pkg", os.Getenv("GOPACKAGE"), "package of the generated file, $GOPACKAGE by default")
+	output      = flag.String("o", "metrics_gen.go", "output Go file")
+	importPath  = flag.String("import", "metrics", "import path of the metrics package")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: metrics-gen -schema file [flags]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 0 || *schemaPath == "" || *packageName == "" {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := run(*schemaPath, *packageName, *output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run Generate the accessors of a schema
+//
+// path Path of the schema
+// pkg Package of the generated file
+// outputPath Path of the generated file
+func run(path string, pkg string, outputPath string) error {
+	metricSchema, err := schema.Load(path)
+	if err != nil {
+		return err
+	}
+
+	src, err := generate(metricSchema, filepath.Base(path), pkg, *importPath)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputPath, src, 0644)
+}
@@ -0,0 +1,89 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+// telemetry-diff compares two telemetry.GetMetricsJSON outputs (ex. from two
+// builds or two runs) and reports the changes per call path.
+//
+// Usage:
+//
+//	telemetry-diff [-format text|json|markdown] [-threshold 0.1] before.json after.json
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"metrics/telemetry"
+)
+
+var (
+	format    = flag.String("format", "text", "output format: text, json or markdown")
+	threshold = flag.Float64("threshold", telemetry.DefaultDiffOptions().RegressionThreshold,
+		"relative average time increase flagged as a regression")
+	minDelta = flag.Float64("min-delta", telemetry.DefaultDiffOptions().MinRegressionMs,
+		"smallest average time increase (ms) flagged as a regression")
+	failOnRegression = flag.Bool("fail", false, "exit with status 1 when there are regressions")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: telemetry-diff [flags] before.json after.json\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	before, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	after, err := os.ReadFile(flag.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	diff, err := telemetry.DiffMetricsJSON(string(before), string(after), telemetry.DiffOptions{
+		RegressionThreshold: *threshold,
+		MinRegressionMs:     *minDelta,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	switch *format {
+	case "text":
+		fmt.Print(diff.Text())
+	case "markdown":
+		fmt.Print(diff.Markdown())
+	case "json":
+		diffJSON, err := diff.JSON()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		fmt.Println(diffJSON)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		os.Exit(2)
+	}
+
+	if *failOnRegression && len(diff.Regressions) > 0 {
+		os.Exit(1)
+	}
+}
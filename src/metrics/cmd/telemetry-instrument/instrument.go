@@ -0,0 +1,373 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Name of the variable holding the context of functions that do not
+// receive one
+const rootContextName = "telemetryContext"
+
+// Comment marking the prologues inserted by the tool, the only ones removed
+const generatedMarker = "// telemetry-instrument"
+
+// instrumenter inserts or removes the telemetry prologue
+//
+//	context = telemetry.FunctionName(context) // telemetry-instrument
+//	if telemetry.IsEnabled() {
+//		defer telemetry.IncreaseFunctionTracer(context, context.Start)
+//	}
+//
+// in every function matching its filters. The start time is the one of the
+// context, so it comes from the telemetry clock (see telemetry.SetClock).
+type instrumenter struct {
+	importPath     string
+	packageFilter  *regexp.Regexp
+	functionFilter *regexp.Regexp
+	remove         bool
+}
+
+// edit Replace src[start:end] by text
+type edit struct {
+	start int
+	end   int
+	text  string
+}
+
+// rewrite Instrument (or uninstrument) a source file
+//
+// filename Name of the file, used for error messages
+// src Contents of the file
+// returns the new contents, equal to src when nothing had to change
+func (in *instrumenter) rewrite(filename string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if in.packageFilter != nil && !in.packageFilter.MatchString(file.Name.Name) {
+		return src, nil
+	}
+
+	telemetryName := importName(file, in.importPath)
+	if telemetryName == "" {
+		if in.remove {
+			return src, nil
+		}
+		telemetryName = in.importPath[strings.LastIndex(in.importPath, "/")+1:]
+	}
+
+	edits := []edit{}
+	for _, decl := range file.Decls {
+		function, ok := decl.(*ast.FuncDecl)
+		if !ok || !in.selected(file, function) {
+			continue
+		}
+
+		prologue := findPrologue(function, telemetryName)
+		if in.remove && prologue != nil && isGenerated(fset, file, prologue) {
+			// Take the surrounding whitespace too so removing undoes inserting
+			start := fset.Position(prologue[0].Pos()).Offset
+			lbrace := fset.Position(function.Body.Lbrace).Offset
+			if strings.TrimSpace(string(src[lbrace+1:start])) == "" {
+				start = lbrace + 1
+			}
+			end := fset.Position(prologue[1].End()).Offset
+			if end < len(src) && src[end] == '\n' {
+				end++
+			}
+			edits = append(edits, edit{start: start, end: end})
+		}
+		if !in.remove && prologue == nil {
+			offset := fset.Position(function.Body.Lbrace).Offset + 1
+			edits = append(edits, edit{start: offset, end: offset, text: in.prologue(function, telemetryName)})
+		}
+	}
+	if len(edits) == 0 {
+		return src, nil
+	}
+
+	return in.format(filename, applyEdits(src, edits))
+}
+
+// selected Check whether a function has to be (un)instrumented
+func (in *instrumenter) selected(file *ast.File, function *ast.FuncDecl) bool {
+	if function.Body == nil {
+		return false
+	}
+
+	// The entry points are the root of the tree, not traced nodes
+	if function.Recv == nil && (function.Name.Name == "init" ||
+		(function.Name.Name == "main" && file.Name.Name == "main")) {
+		return false
+	}
+
+	if in.functionFilter == nil {
+		return true
+	}
+	return in.functionFilter.MatchString(qualifiedName(function))
+}
+
+// prologue Build the prologue text of a function
+func (in *instrumenter) prologue(function *ast.FuncDecl, telemetryName string) string {
+	// Thread the received context by shadowing the parameter itself, this
+	// way every call in the body gets the child context for free
+	contextName := contextParameter(function, telemetryName)
+	assignment := fmt.Sprintf("%s = %s.FunctionName(%s)", contextName, telemetryName, contextName)
+	if contextName == "" {
+		contextName = rootContextName
+		assignment = fmt.Sprintf("%s := %s.FunctionName(%s.Context{FunctionName: %s.GetRoot()})",
+			contextName, telemetryName, telemetryName, telemetryName)
+	}
+
+	return fmt.Sprintf("\n%s %s\nif %s.IsEnabled() {\ndefer %s.IncreaseFunctionTracer(%s, %s.Start)\n}\n",
+		assignment, generatedMarker, telemetryName, telemetryName, contextName, contextName)
+}
+
+// format Fix the imports of an edited file and gofmt it
+func (in *instrumenter) format(filename string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("%s: rewritten source does not parse: %w", filename, err)
+	}
+
+	edits := []edit{}
+	if in.remove {
+		// Only drop imports the prologue was the last user of, time was
+		// used by the prologues of the previous versions of the tool
+		unused := []string{}
+		for _, path := range []string{in.importPath, "time"} {
+			name := importName(file, path)
+			if name != "" && name != "_" && !usesPackage(file, name) {
+				unused = append(unused, path)
+			}
+		}
+		edits = removeImports(fset, file, unused)
+	} else if importName(file, in.importPath) == "" {
+		edits = append(edits, addImport(fset, file, in.importPath))
+	}
+
+	formatted, err := format.Source(applyEdits(src, edits))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return formatted, nil
+}
+
+// findPrologue Find the telemetry prologue of a function
+//
+// returns the first and last statements of the prologue, nil if the
+// function is not instrumented
+func findPrologue(function *ast.FuncDecl, telemetryName string) []ast.Stmt {
+	statements := function.Body.List
+	if len(statements) < 2 {
+		return nil
+	}
+
+	assignment, ok := statements[0].(*ast.AssignStmt)
+	if !ok || len(assignment.Lhs) != 1 || len(assignment.Rhs) != 1 ||
+		!isPackageCall(assignment.Rhs[0], telemetryName, "FunctionName") {
+		return nil
+	}
+	ifStatement, ok := statements[1].(*ast.IfStmt)
+	if !ok || !isPackageCall(ifStatement.Cond, telemetryName, "IsEnabled") {
+		return nil
+	}
+
+	return []ast.Stmt{statements[0], statements[1]}
+}
+
+// isGenerated Check whether a prologue was inserted by this tool, from the
+// marker comment at the end of its first line
+//
+// Hand-written prologues (ex. ctx = telemetry.FunctionName(ctx)) are never
+// removed as the rest of the body may depend on them
+func isGenerated(fset *token.FileSet, file *ast.File, prologue []ast.Stmt) bool {
+	line := fset.Position(prologue[0].End()).Line
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			if fset.Position(comment.Pos()).Line == line && comment.Text == generatedMarker {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contextParameter Get the name of the first telemetry Context parameter
+//
+// returns an empty string if there is no named Context parameter
+func contextParameter(function *ast.FuncDecl, telemetryName string) string {
+	for _, field := range function.Type.Params.List {
+		selector, ok := field.Type.(*ast.SelectorExpr)
+		if !ok || selector.Sel.Name != "Context" {
+			continue
+		}
+		if packageIdent, ok := selector.X.(*ast.Ident); !ok || packageIdent.Name != telemetryName {
+			continue
+		}
+		for _, name := range field.Names {
+			if name.Name != "_" {
+				return name.Name
+			}
+		}
+	}
+	return ""
+}
+
+// qualifiedName Get the name of a function as matched by the filters
+//
+// Ex. Type.Method or Function
+func qualifiedName(function *ast.FuncDecl) string {
+	if function.Recv == nil || len(function.Recv.List) == 0 {
+		return function.Name.Name
+	}
+
+	receiver := function.Recv.List[0].Type
+	for {
+		switch expr := receiver.(type) {
+		case *ast.StarExpr:
+			receiver = expr.X
+			continue
+		case *ast.IndexExpr:
+			receiver = expr.X
+			continue
+		case *ast.IndexListExpr:
+			receiver = expr.X
+			continue
+		case *ast.Ident:
+			return expr.Name + "." + function.Name.Name
+		}
+		return function.Name.Name
+	}
+}
+
+// isPackageCall Check whether expr is a call to packageName.function
+func isPackageCall(expr ast.Expr, packageName string, function string) bool {
+	call, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	selector, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || selector.Sel.Name != function {
+		return false
+	}
+	packageIdent, ok := selector.X.(*ast.Ident)
+	return ok && packageIdent.Name == packageName
+}
+
+// importName Get the name a package is imported as
+//
+// returns an empty string if the package is not imported
+func importName(file *ast.File, path string) string {
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil || importPath != path {
+			continue
+		}
+		if spec.Name != nil {
+			return spec.Name.Name
+		}
+		return path[strings.LastIndex(path, "/")+1:]
+	}
+	return ""
+}
+
+// usesPackage Check whether a file refers to an imported package
+func usesPackage(file *ast.File, name string) bool {
+	used := false
+	ast.Inspect(file, func(node ast.Node) bool {
+		if selector, ok := node.(*ast.SelectorExpr); ok {
+			if packageIdent, ok := selector.X.(*ast.Ident); ok && packageIdent.Name == name {
+				used = true
+			}
+		}
+		return !used
+	})
+	return used
+}
+
+// addImport Build the edit adding an import to a file
+//
+// It is added as a declaration of its own after the existing ones, so
+// removing it leaves the original import declarations untouched
+func addImport(fset *token.FileSet, file *ast.File, path string) edit {
+	offset := fset.Position(file.Name.End()).Offset
+	for _, decl := range file.Decls {
+		importDecl, ok := decl.(*ast.GenDecl)
+		if !ok || importDecl.Tok != token.IMPORT {
+			break
+		}
+		offset = fset.Position(importDecl.End()).Offset
+	}
+	return edit{start: offset, end: offset, text: "\n\nimport " + strconv.Quote(path)}
+}
+
+// removeImports Build the edits removing imports from a file
+//
+// Import declarations left empty are removed altogether
+func removeImports(fset *token.FileSet, file *ast.File, paths []string) []edit {
+	edits := []edit{}
+	for _, decl := range file.Decls {
+		importDecl, ok := decl.(*ast.GenDecl)
+		if !ok || importDecl.Tok != token.IMPORT {
+			continue
+		}
+
+		specEdits := []edit{}
+		for _, spec := range importDecl.Specs {
+			importSpec := spec.(*ast.ImportSpec)
+			importPath, _ := strconv.Unquote(importSpec.Path.Value)
+			for _, path := range paths {
+				if importPath == path {
+					specEdits = append(specEdits, edit{
+						start: fset.Position(importSpec.Pos()).Offset,
+						end:   fset.Position(importSpec.End()).Offset,
+					})
+				}
+			}
+		}
+
+		if len(specEdits) == len(importDecl.Specs) {
+			edits = append(edits, edit{
+				start: fset.Position(importDecl.Pos()).Offset,
+				end:   fset.Position(importDecl.End()).Offset,
+			})
+			continue
+		}
+		edits = append(edits, specEdits...)
+	}
+	return edits
+}
+
+// applyEdits Apply non-overlapping edits to src
+func applyEdits(src []byte, edits []edit) []byte {
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].start > edits[j].start
+	})
+
+	result := append([]byte{}, src...)
+	for _, e := range edits {
+		result = append(result[:e.start], append([]byte(e.text), result[e.end:]...)...)
+	}
+	return result
+}
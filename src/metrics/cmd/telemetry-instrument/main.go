@@ -0,0 +1,208 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+// telemetry-instrument inserts (or removes) the telemetry prologue in every
+// function matching the given filters, meant for temporary deep-profiling
+// builds.
+//
+// Usage:
+//
+//	telemetry-instrument [flags] [path ...]
+//
+// Paths can be files, directories or directories followed by /... to walk
+// them recursively. Running it twice is harmless: instrumented functions are
+// detected and left alone.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	write          = flag.Bool("w", false, "write result to (source) file instead of stdout")
+	diff           = flag.Bool("d", false, "display diffs instead of rewriting files (dry run)")
+	remove         = flag.Bool("remove", false, "remove the telemetry prologue instead of inserting it")
+	importPath     = flag.String("import", "metrics/telemetry", "import path of the telemetry package")
+	packagePattern = flag.String("pkg", "", "only process packages whose name matches this regexp")
+	funcPattern    = flag.String("func", "", "only process functions (or Type.Method) matching this regexp")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: telemetry-instrument [flags] [path ...]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	in := &instrumenter{
+		importPath: *importPath,
+		remove:     *remove,
+	}
+	var err error
+	if *packagePattern != "" {
+		if in.packageFilter, err = regexp.Compile(*packagePattern); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -pkg: %s\n", err)
+			os.Exit(2)
+		}
+	}
+	if *funcPattern != "" {
+		if in.functionFilter, err = regexp.Compile(*funcPattern); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -func: %s\n", err)
+			os.Exit(2)
+		}
+	}
+
+	exitCode := 0
+	for _, path := range flag.Args() {
+		files, err := goFiles(path, in.importPath)
+		if err == nil {
+			for _, filename := range files {
+				if err = processFile(in, filename); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitCode = 2
+		}
+	}
+	os.Exit(exitCode)
+}
+
+// goFiles List the Go files to process for a path argument
+//
+// Test files, generated files and the telemetry package itself are skipped
+func goFiles(path string, importPath string) ([]string, error) {
+	recursive := strings.HasSuffix(path, "/...")
+	if recursive {
+		path = strings.TrimSuffix(path, "/...")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	files := []string{}
+	err = filepath.WalkDir(path, func(filename string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			name := entry.Name()
+			if filename != path && (!recursive || name == "vendor" || name == "testdata" ||
+				strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			absolute, err := filepath.Abs(filename)
+			if err == nil && strings.HasSuffix(filepath.ToSlash(absolute), "/"+importPath) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(filename, ".go") && !strings.HasSuffix(filename, "_test.go") {
+			files = append(files, filename)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Generated files must not be edited by hand (or tools)
+var generatedPattern = regexp.MustCompile(`(?m)^// Code generated .* DO NOT EDIT\.$`)
+
+// processFile Rewrite one file according to the output flags
+func processFile(in *instrumenter, filename string) error {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if generatedPattern.Match(src) {
+		return nil
+	}
+
+	result, err := in.rewrite(filename, src)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *diff:
+		if bytes.Equal(src, result) {
+			return nil
+		}
+		return printDiff(filename, src, result)
+
+	case *write:
+		if bytes.Equal(src, result) {
+			return nil
+		}
+		info, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filename, result, info.Mode().Perm())
+
+	default:
+		_, err = os.Stdout.Write(result)
+		return err
+	}
+}
+
+// printDiff Print a unified diff between two versions of a file
+//
+// Relies on the system diff tool as gofmt -d does
+func printDiff(filename string, before []byte, after []byte) error {
+	dir, err := os.MkdirTemp("", "telemetry-instrument")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	beforeFile := filepath.Join(dir, "before.go")
+	afterFile := filepath.Join(dir, "after.go")
+	if err := os.WriteFile(beforeFile, before, 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(afterFile, after, 0600); err != nil {
+		return err
+	}
+
+	output, err := exec.Command("diff", "-u", "--label", filename+".orig", "--label", filename,
+		beforeFile, afterFile).Output()
+	// diff exits with 1 when the files differ
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("computing diff: %w", err)
+	}
+
+	_, err = os.Stdout.Write(output)
+	return err
+}
@@ -0,0 +1,45 @@
+(function() {
+  // Call tree: expand/collapse every node at once
+  document.querySelectorAll('button[data-tree]').forEach(function(button) {
+    button.addEventListener('click', function() {
+      var open = button.dataset.tree === 'open';
+      document.querySelectorAll('.tree details').forEach(function(node) {
+        node.open = open;
+      });
+    });
+  });
+
+  // Icicle view: zoom into a frame by stretching it to the full width
+  var frames = document.querySelectorAll('#flame .frame');
+
+  function zoom(x, width, y) {
+    frames.forEach(function(frame) {
+      var frameX = parseFloat(frame.dataset.x);
+      var frameWidth = parseFloat(frame.dataset.width);
+      var inside = frameX + frameWidth > x && frameX < x + width;
+      // Ancestors of the zoomed frame span the whole view
+      var left = Math.max(frameX, x);
+      var right = Math.min(frameX + frameWidth, x + width);
+
+      frame.style.display = inside ? '' : 'none';
+      frame.setAttribute('x', ((left - x) * 100 / width) + '%');
+      frame.setAttribute('width', ((right - left) * 100 / width) + '%');
+      frame.style.opacity = parseInt(frame.getAttribute('y'), 10) < y ? 0.5 : 1;
+    });
+  }
+
+  frames.forEach(function(frame) {
+    frame.addEventListener('click', function() {
+      var y = parseInt(frame.getAttribute('y'), 10);
+      if (y === 0 && frame.getAttribute('width') === '100%') {
+        zoom(0, 100, 0);
+        return;
+      }
+      zoom(parseFloat(frame.dataset.x), parseFloat(frame.dataset.width), y);
+    });
+  });
+
+  document.getElementById('flame-reset').addEventListener('click', function() {
+    zoom(0, 100, 0);
+  });
+})();
@@ -0,0 +1,119 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+// telemetry-report turns the JSON tree returned by telemetry.GetMetricsJSON
+// into a single, self-contained (offline) HTML report with a collapsible call
+// tree, sorted per-function charts and an icicle view.
+//
+// Usage:
+//
+//	telemetry-report [-o telemetry.html] [-title title] [-flame time|bytes|objects] [input.json]
+//
+// The JSON is read from stdin when no input file is given. -flame selects the
+// width of the icicle view frames: time or, for trees recorded with
+// allocation tracking, allocated bytes or objects.
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+
+	gometrics "metrics"
+)
+
+var (
+	output = flag.String("o", "telemetry.html", "output HTML file")
+	title  = flag.String("title", "Telemetry metrics", "title of the report")
+	flame  = flag.String("flame", "time", "width of the icicle view frames: time, bytes or objects")
+)
+
+//go:embed assets/report.html.tmpl
+var reportTemplate string
+
+//go:embed assets/report.css
+var reportCSS string
+
+//go:embed assets/report.js
+var reportJS string
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: telemetry-report [flags] [input.json]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() > 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	weight, err := gometrics.ParseFlameWeight(*flame)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), *output, weight); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run Generate the report
+//
+// input Path of the telemetry JSON, stdin if empty
+// outputPath Path of the HTML report
+// weight Width of the icicle view frames
+func run(input string, outputPath string, weight gometrics.FlameWeight) error {
+	var reader io.Reader = os.Stdin
+	if input != "" {
+		file, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	tree := gometrics.FunctionTracerMetricsDTO{}
+	if err := json.NewDecoder(reader).Decode(&tree); err != nil {
+		return fmt.Errorf("Unable to parse the telemetry JSON: %w", err)
+	}
+
+	page, err := template.New("report").Funcs(template.FuncMap{
+		"css": func() template.CSS { return template.CSS(reportCSS) },
+		"js":  func() template.JS { return template.JS(reportJS) },
+	}).Parse(reportTemplate)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	if err := page.Execute(file, newReport(*title, &tree, weight)); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Output HTML: %s\n", outputPath)
+	return nil
+}
@@ -0,0 +1,285 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"time"
+
+	gometrics "metrics"
+)
+
+// Report view model rendered by the HTML template
+type report struct {
+	Title       string
+	GeneratedAt string
+	Root        string
+	Calls       int
+	TotalTimeMs int
+	ErrorCount  int
+	PanicCount  int
+	Tree        []*treeNode
+	Charts      []*chart
+	Flame       []*flameRect
+	FlameHeight int
+	FlameUnit   string
+}
+
+// treeNode One call of the collapsible call tree
+type treeNode struct {
+	Function      string
+	CallID        string
+	Calls         int
+	TotalTimeMs   int
+	AverageTimeMs float64
+	ErrorCount    int
+	PanicCount    int
+	AllocBytes    uint64
+	AllocObjects  uint64
+	Percent       float64
+	Children      []*treeNode
+}
+
+// chart Horizontal bar chart of one per-function statistic
+type chart struct {
+	ID    string
+	Title string
+	Unit  string
+	Bars  []*bar
+}
+
+// bar One function of a chart
+type bar struct {
+	Function string
+	Value    float64
+	Percent  float64
+}
+
+// flameRect One frame of the icicle view
+//
+// X and Width are percentages of the root so the view scales with the page
+type flameRect struct {
+	Function string
+	Value    uint64
+	X        float64
+	Width    float64
+	Y        int
+	Color    string
+}
+
+// Height in pixels of every icicle row
+const flameRowHeight = 18
+
+// Units of the icicle view values
+var flameUnits = map[gometrics.FlameWeight]string{
+	gometrics.FlameTime:         "ms",
+	gometrics.FlameAllocBytes:   "bytes",
+	gometrics.FlameAllocObjects: "objects",
+}
+
+// functionStats Statistics of all the calls to one function
+type functionStats struct {
+	calls     int
+	totalMs   int
+	durations []float64
+}
+
+// newReport Build the report view model from a telemetry tree
+//
+// title Title of the report
+// tree Tree returned by telemetry.GetMetricsJSON
+// weight Statistic used as the width of the icicle view frames
+func newReport(title string, tree *gometrics.FunctionTracerMetricsDTO, weight gometrics.FlameWeight) *report {
+	r := &report{
+		Title:       title,
+		GeneratedAt: time.Now().Format(time.RFC1123),
+		Root:        tree.Function,
+		ErrorCount:  tree.ErrorCount,
+		PanicCount:  tree.PanicCount,
+		FlameUnit:   flameUnits[weight],
+	}
+	for _, child := range tree.Children {
+		r.TotalTimeMs += child.TotalTimeMs
+	}
+
+	stats := map[string]*functionStats{}
+	for _, child := range tree.Children {
+		r.Tree = append(r.Tree, r.buildTree(child, stats))
+	}
+	r.Charts = buildCharts(stats)
+
+	rootValue := uint64(0)
+	for _, child := range tree.Children {
+		rootValue += flameValue(child, weight)
+	}
+	x := 0.0
+	for _, child := range tree.Children {
+		x += r.buildFlame(child, x, 0, float64(rootValue), weight)
+	}
+
+	return r
+}
+
+// buildTree Convert a call (and its children) to the view model while
+// collecting the per-function statistics
+func (r *report) buildTree(node *gometrics.FunctionTracerMetricsDTO, stats map[string]*functionStats) *treeNode {
+	function := gometrics.GetName(node.Function)
+	average := getAverage(node.TotalTimeMs, node.Calls)
+
+	r.Calls += node.Calls
+	functionStat, ok := stats[function]
+	if !ok {
+		functionStat = &functionStats{}
+		stats[function] = functionStat
+	}
+	functionStat.calls += node.Calls
+	functionStat.totalMs += node.TotalTimeMs
+	// Nodes normally hold a single call, otherwise only the average is known
+	for i := 0; i < node.Calls; i++ {
+		functionStat.durations = append(functionStat.durations, average)
+	}
+
+	result := &treeNode{
+		Function:      function,
+		CallID:        gometrics.GetSuffix(node.Function),
+		Calls:         node.Calls,
+		TotalTimeMs:   node.TotalTimeMs,
+		AverageTimeMs: average,
+		ErrorCount:    node.ErrorCount,
+		PanicCount:    node.PanicCount,
+		AllocBytes:    node.AllocBytes,
+		AllocObjects:  node.AllocObjects,
+	}
+	if r.TotalTimeMs > 0 {
+		result.Percent = 100 * float64(node.TotalTimeMs) / float64(r.TotalTimeMs)
+	}
+	for _, child := range node.Children {
+		result.Children = append(result.Children, r.buildTree(child, stats))
+	}
+	return result
+}
+
+// buildCharts Build the sorted (descending) charts of every statistic
+func buildCharts(stats map[string]*functionStats) []*chart {
+	charts := []*chart{
+		{ID: "calls", Title: "Calls"},
+		{ID: "total", Title: "Total time", Unit: "ms"},
+		{ID: "average", Title: "Average time", Unit: "ms"},
+		{ID: "p99", Title: "p99 time", Unit: "ms"},
+	}
+	for function, functionStat := range stats {
+		values := []float64{
+			float64(functionStat.calls),
+			float64(functionStat.totalMs),
+			getAverage(functionStat.totalMs, functionStat.calls),
+			getPercentile(functionStat.durations, 0.99),
+		}
+		for i, value := range values {
+			charts[i].Bars = append(charts[i].Bars, &bar{Function: function, Value: value})
+		}
+	}
+
+	for _, c := range charts {
+		sort.Slice(c.Bars, func(i, j int) bool {
+			if c.Bars[i].Value != c.Bars[j].Value {
+				return c.Bars[i].Value > c.Bars[j].Value
+			}
+			return c.Bars[i].Function < c.Bars[j].Function
+		})
+		if len(c.Bars) > 0 && c.Bars[0].Value > 0 {
+			for _, b := range c.Bars {
+				b.Percent = 100 * b.Value / c.Bars[0].Value
+			}
+		}
+	}
+	return charts
+}
+
+// flameValue Get the width of a call in the icicle view
+//
+// Goroutines started by a call may outlive it, so a call is at least as
+// wide as its children. Every call is at least 1 (ms, byte or object) wide to
+// stay visible.
+func flameValue(node *gometrics.FunctionTracerMetricsDTO, weight gometrics.FlameWeight) uint64 {
+	childrenValue := uint64(0)
+	for _, child := range node.Children {
+		childrenValue += flameValue(child, weight)
+	}
+	value := gometrics.GetFlameValue(node, weight)
+	if childrenValue > value {
+		value = childrenValue
+	}
+	if value < 1 {
+		value = 1
+	}
+	return value
+}
+
+// buildFlame Lay out a call (and its children) in the icicle view
+//
+// returns the width taken by the call
+func (r *report) buildFlame(node *gometrics.FunctionTracerMetricsDTO, x float64, depth int, rootValue float64,
+	weight gometrics.FlameWeight) float64 {
+	width := 100 * float64(flameValue(node, weight)) / rootValue
+	function := gometrics.GetName(node.Function)
+	r.Flame = append(r.Flame, &flameRect{
+		Function: function,
+		Value:    gometrics.GetFlameValue(node, weight),
+		X:        x,
+		Width:    width,
+		Y:        depth * flameRowHeight,
+		Color:    getColor(function),
+	})
+	if height := (depth + 1) * flameRowHeight; height > r.FlameHeight {
+		r.FlameHeight = height
+	}
+
+	childX := x
+	for _, child := range node.Children {
+		childX += r.buildFlame(child, childX, depth+1, rootValue, weight)
+	}
+	return width
+}
+
+// getAverage Calculate the average
+func getAverage(total int, count int) float64 {
+	if count <= 0 {
+		return 0
+	}
+
+	return float64(total) / float64(count)
+}
+
+// getPercentile Calculate a percentile using the nearest-rank method
+func getPercentile(values []float64, percentile float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(percentile*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// getColor Get a stable warm color for a function
+func getColor(function string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(function))
+	sum := hash.Sum32()
+	return fmt.Sprintf("hsl(%d, %d%%, %d%%)", sum%50, 70+sum/50%20, 55+sum/1000%10)
+}
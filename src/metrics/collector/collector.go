@@ -0,0 +1,142 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+// Package collector registers the standard Go runtime and process metrics
+// into a Metrics
+//
+// The values are read through callback metrics (GaugeFunc, CounterFunc and
+// HistogramFunc) so they are refreshed every time the metrics are read or
+// exported, there is no polling goroutine. Names follow the Prometheus
+// conventions (snake_case, base units and a _total suffix for counters).
+package collector
+
+import (
+	"math"
+	"runtime/metrics"
+
+	gometrics "metrics"
+	metricTypes "metrics/metrictypes"
+)
+
+// Names of the collected metrics
+const (
+	GoGoroutines               = "go_goroutines"
+	GoHeapAllocBytes           = "go_memstats_heap_alloc_bytes"
+	GoHeapInuseBytes           = "go_memstats_heap_inuse_bytes"
+	GoGCCyclesTotal            = "go_gc_cycles_total"
+	GoGCPauseSeconds           = "go_gc_pause_seconds"
+	ProcessOpenFDs             = "process_open_fds"
+	ProcessResidentMemoryBytes = "process_resident_memory_bytes"
+	ProcessCPUSecondsTotal     = "process_cpu_seconds_total"
+)
+
+// Names of the runtime/metrics samples
+const (
+	goroutinesSample  = "/sched/goroutines:goroutines"
+	heapObjectsSample = "/memory/classes/heap/objects:bytes"
+	heapUnusedSample  = "/memory/classes/heap/unused:bytes"
+	gcCyclesSample    = "/gc/cycles/total:gc-cycles"
+	gcPausesSample    = "/sched/pauses/total/gc:seconds"
+)
+
+// GCPauseBuckets Upper bounds (in seconds) of the buckets of the GC pause
+// histogram
+var GCPauseBuckets = []float64{
+	0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005,
+	0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1,
+}
+
+// Register adds the runtime and process metrics to a Metrics
+//
+// metrics Metrics to add the metrics to, existing metrics with the same
+// names are replaced
+func Register(metrics *gometrics.Metrics) {
+	RegisterRuntime(metrics)
+	RegisterProcess(metrics)
+}
+
+// RegisterRuntime adds the Go runtime metrics to a Metrics
+//
+// - go_goroutines: number of live goroutines
+// - go_memstats_heap_alloc_bytes: bytes of allocated heap objects
+// - go_memstats_heap_inuse_bytes: bytes of in-use heap spans
+// - go_gc_cycles_total: number of completed GC cycles
+// - go_gc_pause_seconds: histogram of the stop-the-world GC pauses
+func RegisterRuntime(metrics *gometrics.Metrics) {
+	metrics.AddGaugeFunc(GoGoroutines, func() float64 {
+		return float64(readUint64(goroutinesSample))
+	})
+	metrics.AddGaugeFunc(GoHeapAllocBytes, func() float64 {
+		return float64(readUint64(heapObjectsSample))
+	})
+	metrics.AddGaugeFunc(GoHeapInuseBytes, func() float64 {
+		return float64(readUint64(heapObjectsSample, heapUnusedSample))
+	})
+	metrics.AddCounterFunc(GoGCCyclesTotal, func() int {
+		return int(readUint64(gcCyclesSample))
+	})
+	metrics.AddHistogramFunc(GoGCPauseSeconds, readGCPauses)
+}
+
+// readUint64 Read runtime samples of kind uint64
+//
+// names Names of the samples
+// returns the sum of the samples, unsupported ones count as 0
+func readUint64(names ...string) uint64 {
+	samples := make([]metrics.Sample, len(names))
+	for i, name := range names {
+		samples[i].Name = name
+	}
+	metrics.Read(samples)
+
+	total := uint64(0)
+	for _, sample := range samples {
+		if sample.Value.Kind() == metrics.KindUint64 {
+			total += sample.Value.Uint64()
+		}
+	}
+	return total
+}
+
+// readGCPauses Read the histogram of GC pauses
+//
+// The runtime buckets are much finer than GCPauseBuckets, each of them is
+// counted at its midpoint, which is also used to estimate the Sum
+func readGCPauses() gometrics.HistogramValue {
+	histogram := metricTypes.NewHistogram(GCPauseBuckets)
+
+	samples := []metrics.Sample{{Name: gcPausesSample}}
+	metrics.Read(samples)
+	if samples[0].Value.Kind() != metrics.KindFloat64Histogram {
+		return histogram
+	}
+
+	runtimeHistogram := samples[0].Value.Float64Histogram()
+	for i, count := range runtimeHistogram.Counts {
+		if count == 0 {
+			continue
+		}
+		histogram.Observe(getMidpoint(runtimeHistogram.Buckets[i], runtimeHistogram.Buckets[i+1]), int(count))
+	}
+	return histogram
+}
+
+// getMidpoint Get the midpoint of a runtime bucket, the bounds may be
+// infinite
+func getMidpoint(lower float64, upper float64) float64 {
+	switch {
+	case math.IsInf(lower, -1):
+		return upper
+	case math.IsInf(upper, 1):
+		return lower
+	default:
+		return (lower + upper) / 2
+	}
+}
@@ -0,0 +1,146 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+//go:build linux
+
+package collector
+
+import (
+	"encoding/binary"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	gometrics "metrics"
+)
+
+// defaultUserHZ Clock ticks per second of the CPU times in /proc when the
+// kernel does not report it. It is 100 on every supported Linux
+// architecture
+const defaultUserHZ = 100
+
+// atClockTick Type of the auxiliary vector entry holding the clock ticks
+// per second (AT_CLKTCK, the value returned by sysconf(_SC_CLK_TCK))
+const atClockTick = 17
+
+var (
+	userHZ     float64
+	userHZOnce sync.Once
+)
+
+// RegisterProcess adds the process metrics to a Metrics, read from
+// /proc/self. It does nothing on other operating systems.
+//
+// - process_open_fds: number of open file descriptors
+// - process_resident_memory_bytes: resident set size
+// - process_cpu_seconds_total: user and system CPU time in seconds, a
+// GaugeFunc since the counter funcs are integers, but monotonic like a Counter
+//
+// The metrics read 0 if /proc cannot be read
+func RegisterProcess(metrics *gometrics.Metrics) {
+	metrics.AddGaugeFunc(ProcessOpenFDs, func() float64 {
+		entries, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			return 0
+		}
+		return float64(len(entries))
+	})
+	metrics.AddGaugeFunc(ProcessResidentMemoryBytes, func() float64 {
+		stat := readStat()
+		if stat == nil {
+			return 0
+		}
+		return parseStatField(stat, 24) * float64(os.Getpagesize())
+	})
+	metrics.AddGaugeFunc(ProcessCPUSecondsTotal, func() float64 {
+		stat := readStat()
+		if stat == nil {
+			return 0
+		}
+		// utime and stime are in clock ticks
+		return (parseStatField(stat, 14) + parseStatField(stat, 15)) / getUserHZ()
+	})
+}
+
+// getUserHZ Get the clock ticks per second of the CPU times in /proc
+//
+// It is read once from the auxiliary vector of the process, defaultUserHZ
+// is used if it cannot be read
+func getUserHZ() float64 {
+	userHZOnce.Do(func() {
+		userHZ = defaultUserHZ
+		if hz := readAuxv(atClockTick); hz > 0 {
+			userHZ = float64(hz)
+		}
+	})
+	return userHZ
+}
+
+// readAuxv Read an entry of /proc/self/auxv
+//
+// entryType Type of the entry (AT_* constant)
+// returns the value of the entry, 0 if it is missing or cannot be read
+func readAuxv(entryType uint64) uint64 {
+	data, err := os.ReadFile("/proc/self/auxv")
+	if err != nil {
+		return 0
+	}
+
+	// Pairs of native words: type, value
+	wordSize := strconv.IntSize / 8
+	readWord := func(word []byte) uint64 {
+		if wordSize == 4 {
+			return uint64(binary.NativeEndian.Uint32(word))
+		}
+		return binary.NativeEndian.Uint64(word)
+	}
+	for offset := 0; offset+2*wordSize <= len(data); offset += 2 * wordSize {
+		if readWord(data[offset:]) == entryType {
+			return readWord(data[offset+wordSize:])
+		}
+	}
+	return 0
+}
+
+// readStat Read the fields of /proc/self/stat following the command name
+//
+// returns nil if it cannot be read
+func readStat() []string {
+	data, err := os.ReadFile("/proc/self/stat")
+	if err != nil {
+		return nil
+	}
+
+	// The command name is between parenthesis and may contain spaces
+	stat := string(data)
+	end := strings.LastIndex(stat, ")")
+	if end < 0 {
+		return nil
+	}
+	return strings.Fields(stat[end+1:])
+}
+
+// parseStatField Parse a numeric field of /proc/self/stat
+//
+// stat Fields following the command name, as returned by readStat
+// field Number of the field as documented in proc(5), starting at 1
+func parseStatField(stat []string, field int) float64 {
+	// Fields 1 (pid) and 2 (comm) are not part of stat
+	index := field - 3
+	if index < 0 || index >= len(stat) {
+		return 0
+	}
+	value, err := strconv.ParseFloat(stat[index], 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
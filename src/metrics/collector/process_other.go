@@ -0,0 +1,22 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+//go:build !linux
+
+package collector
+
+import (
+	gometrics "metrics"
+)
+
+// RegisterProcess adds the process metrics to a Metrics. They are read from
+// /proc so it does nothing on this operating system.
+func RegisterProcess(metrics *gometrics.Metrics) {
+}
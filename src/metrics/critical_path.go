@@ -0,0 +1,130 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package metrics
+
+import (
+	"sort"
+	"time"
+)
+
+// CriticalPathDTO Critical path of a call: the chain of calls that
+// determined its end-to-end latency
+type CriticalPathDTO struct {
+	Function   string
+	DurationMs float64
+	// Steps Calls on the critical path, in chronological order. Their
+	// contributions add up to DurationMs
+	Steps []*CriticalPathStepDTO
+	// OffPath Calls that did not determine the latency along with their
+	// slack
+	OffPath []*CriticalPathStepDTO
+}
+
+// CriticalPathStepDTO One call of the critical path analysis
+type CriticalPathStepDTO struct {
+	Function   string
+	Depth      int
+	StartTime  time.Time
+	EndTime    time.Time
+	DurationMs float64
+	// ContributionMs Time of the call itself (excluding its children) spent
+	// on the critical path
+	ContributionMs float64
+	// SlackMs How much longer an off-path call could have taken without
+	// delaying its caller
+	SlackMs float64
+	// Detached Set for calls (ex. goroutines) that ended after their caller
+	// so they could not delay it
+	Detached bool
+}
+
+// GetCriticalPath Compute the critical path of a call
+//
+// The path is built backwards from the end of the call: the child that
+// ended last before the current point is the one the caller was waiting for,
+// the time in between is the caller's own work. Children running in
+// goroutines that outlive their caller are detached from its path.
+//
+// call Call to analyze, it needs StartTime and EndTime
+func GetCriticalPath(call *FunctionTracerMetricsDTO) *CriticalPathDTO {
+	criticalPath := &CriticalPathDTO{
+		Function:   GetName(call.Function),
+		DurationMs: getDurationMs(call.StartTime, call.EndTime),
+		Steps:      []*CriticalPathStepDTO{},
+		OffPath:    []*CriticalPathStepDTO{},
+	}
+	criticalPath.walk(call, 0)
+
+	sort.SliceStable(criticalPath.Steps, func(i, j int) bool {
+		return criticalPath.Steps[i].StartTime.Before(criticalPath.Steps[j].StartTime)
+	})
+	return criticalPath
+}
+
+// walk Add a call on the critical path and walk its children
+func (cp *CriticalPathDTO) walk(call *FunctionTracerMetricsDTO, depth int) {
+	step := newCriticalPathStep(call, depth)
+	cp.Steps = append(cp.Steps, step)
+
+	children := append([]*FunctionTracerMetricsDTO{}, call.Children...)
+	sort.SliceStable(children, func(i, j int) bool {
+		return children[i].EndTime.After(children[j].EndTime)
+	})
+
+	// cursor Point in time the walk has reached
+	// criticalEnd End of the last child put on the critical path
+	cursor := call.EndTime
+	criticalEnd := call.EndTime
+	contribution := time.Duration(0)
+	for _, child := range children {
+		childStep := newCriticalPathStep(child, depth+1)
+		switch {
+		case child.EndTime.After(call.EndTime):
+			childStep.Detached = true
+			cp.OffPath = append(cp.OffPath, childStep)
+
+		case child.EndTime.After(cursor):
+			// Ran concurrently with a child on the critical path, it would
+			// delay the caller only by ending after it
+			childStep.SlackMs = getDurationMs(child.EndTime, criticalEnd)
+			cp.OffPath = append(cp.OffPath, childStep)
+
+		default:
+			contribution += cursor.Sub(child.EndTime)
+			criticalEnd = child.EndTime
+			cp.walk(child, depth+1)
+			if child.StartTime.Before(cursor) {
+				cursor = child.StartTime
+			}
+		}
+	}
+	if cursor.After(call.StartTime) {
+		contribution += cursor.Sub(call.StartTime)
+	}
+
+	step.ContributionMs = float64(contribution) / float64(time.Millisecond)
+}
+
+// newCriticalPathStep Create the step of a call
+func newCriticalPathStep(call *FunctionTracerMetricsDTO, depth int) *CriticalPathStepDTO {
+	return &CriticalPathStepDTO{
+		Function:   GetName(call.Function),
+		Depth:      depth,
+		StartTime:  call.StartTime,
+		EndTime:    call.EndTime,
+		DurationMs: getDurationMs(call.StartTime, call.EndTime),
+	}
+}
+
+// getDurationMs Get the time between two points in milliseconds
+func getDurationMs(start time.Time, end time.Time) float64 {
+	return float64(end.Sub(start)) / float64(time.Millisecond)
+}
@@ -10,19 +10,81 @@
 package error
 
 import (
+	"errors"
 	"fmt"
 )
 
+// Code is a stable, machine-readable identifier of an error kind
+type Code string
+
+// Available error codes
+const (
+	CodeMetricNotFound         Code = "METRIC_NOT_FOUND"
+	CodeCounterNotFound        Code = "COUNTER_NOT_FOUND"
+	CodeMetricInvalidType      Code = "METRIC_INVALID_TYPE"
+	CodeMetricInvalidOperation Code = "METRIC_INVALID_OPERATION"
+	CodeValueAssertionInvalid  Code = "VALUE_ASSERTION_INVALID"
+	CodeCheckpointInvalid      Code = "CHECKPOINT_INVALID"
+	CodeSchemaInvalid          Code = "SCHEMA_INVALID"
+	CodeRuleInvalid            Code = "RULE_INVALID"
+	CodeCardinalityExceeded    Code = "CARDINALITY_EXCEEDED"
+)
+
+// kindError is the type of the sentinel errors, one per error kind
+type kindError struct {
+	code    Code
+	message string
+}
+
+// Error implements the error interface
+func (e *kindError) Error() string {
+	return e.message
+}
+
+// Code returns the code of the error kind
+func (e *kindError) Code() Code {
+	return e.code
+}
+
+// Sentinel errors to be used with errors.Is, every typed error below
+// matches the sentinel of its kind
+//
+// Ex. errors.Is(err, ErrMetricNotFound)
+var (
+	ErrMetricNotFound         error = &kindError{CodeMetricNotFound, "metric was not found"}
+	ErrCounterNotFound        error = &kindError{CodeCounterNotFound, "counter was not found"}
+	ErrMetricInvalidType      error = &kindError{CodeMetricInvalidType, "metric does not match with value"}
+	ErrMetricInvalidOperation error = &kindError{CodeMetricInvalidOperation, "metric does not support operation"}
+	ErrValueAssertionInvalid  error = &kindError{CodeValueAssertionInvalid, "metric data could not be asserted"}
+	ErrCheckpointInvalid      error = &kindError{CodeCheckpointInvalid, "checkpoint could not be restored"}
+	ErrSchemaInvalid          error = &kindError{CodeSchemaInvalid, "metric schema is invalid"}
+	ErrRuleInvalid            error = &kindError{CodeRuleInvalid, "alert rule is invalid"}
+	ErrCardinalityExceeded    error = &kindError{CodeCardinalityExceeded, "metric cardinality limit was exceeded"}
+)
+
+// GetCode returns the code of the first metrics error in the chain of err
+//
+// returns an empty Code if err is not (or does not wrap) a metrics error
+func GetCode(err error) Code {
+	var coded interface{ Code() Code }
+	if errors.As(err, &coded) {
+		return coded.Code()
+	}
+	return ""
+}
+
 // MetricNotFound represents an error when a metric name is
 // not found among the available metrics
 type MetricNotFound struct {
 	MetricName string
+	Cause      error
 }
 
 // CounterNotFound represents an error when a counter name is
 // not found among the available counter
 type CounterNotFound struct {
 	CounterName string
+	Cause       error
 }
 
 // MetricInvalidType represents an error when a metric type is
@@ -30,14 +92,16 @@ type CounterNotFound struct {
 type MetricInvalidType struct {
 	MetricName string
 	MetricType string
+	Cause      error
 }
 
 // MetricInvalidOperation represents an error when a metric type does
 // not support metric function
 type MetricInvalidOperation struct {
-	MetricName string
-	MetricType string
+	MetricName      string
+	MetricType      string
 	MetricOperation string
+	Cause           error
 }
 
 // ValueAssertionInvalid represents an error when an interface{} value
@@ -45,34 +109,240 @@ type MetricInvalidOperation struct {
 type ValueAssertionInvalid struct {
 	Value        interface{}
 	ExpectedType string
+	Cause        error
+}
+
+// CheckpointInvalid represents an error when a checkpoint file is
+// corrupted or can not be understood
+type CheckpointInvalid struct {
+	Path   string
+	Reason string
+	Cause  error
+}
+
+// SchemaInvalid represents an error when a metric schema can not be
+// parsed or does not define valid metrics
+type SchemaInvalid struct {
+	Path   string
+	Field  string
+	Reason string
+	Cause  error
+}
+
+// RuleInvalid represents an error when an alert rule can not be parsed
+type RuleInvalid struct {
+	Rule   string
+	Expr   string
+	Reason string
+	Cause  error
+}
+
+// CardinalityExceeded represents an error when a series can not be added
+// without exceeding a cardinality limit, its values are folded into the
+// Overflow series instead (or dropped if it is empty)
+type CardinalityExceeded struct {
+	MetricName string
+	Limit      int
+	Overflow   string
+	Cause      error
+}
+
+// withCause appends the underlying cause (if any) to an error message
+func withCause(message string, cause error) string {
+	if cause == nil {
+		return message
+	}
+	return message + " cause=" + cause.Error()
 }
 
 // MetricNotFound implements the error interface
 func (e MetricNotFound) Error() string {
 	err := "Error: " + fmt.Sprintf(MetricNotFoundMsg, e.MetricName)
-	return err
+	return withCause(err, e.Cause)
+}
+
+// Unwrap returns the underlying cause
+func (e MetricNotFound) Unwrap() error {
+	return e.Cause
+}
+
+// Is matches the ErrMetricNotFound sentinel
+func (e MetricNotFound) Is(target error) bool {
+	return target == ErrMetricNotFound
+}
+
+// Code returns CodeMetricNotFound
+func (e MetricNotFound) Code() Code {
+	return CodeMetricNotFound
 }
 
 // CounterNotFound implements the error interface
 func (e CounterNotFound) Error() string {
 	err := "Error: " + fmt.Sprintf(CounterNotFoundMsg, e.CounterName)
-	return err
+	return withCause(err, e.Cause)
+}
+
+// Unwrap returns the underlying cause
+func (e CounterNotFound) Unwrap() error {
+	return e.Cause
+}
+
+// Is matches the ErrCounterNotFound sentinel
+func (e CounterNotFound) Is(target error) bool {
+	return target == ErrCounterNotFound
+}
+
+// Code returns CodeCounterNotFound
+func (e CounterNotFound) Code() Code {
+	return CodeCounterNotFound
 }
 
 // MetricInvalidType implements the error interface
 func (e MetricInvalidType) Error() string {
 	err := "Error: " + fmt.Sprintf(MetricInvalidTypeMsg, e.MetricName, e.MetricType)
-	return err
+	return withCause(err, e.Cause)
 }
 
-// MetricInvalidType implements the error interface
+// Unwrap returns the underlying cause
+func (e MetricInvalidType) Unwrap() error {
+	return e.Cause
+}
+
+// Is matches the ErrMetricInvalidType sentinel
+func (e MetricInvalidType) Is(target error) bool {
+	return target == ErrMetricInvalidType
+}
+
+// Code returns CodeMetricInvalidType
+func (e MetricInvalidType) Code() Code {
+	return CodeMetricInvalidType
+}
+
+// MetricInvalidOperation implements the error interface
 func (e MetricInvalidOperation) Error() string {
 	err := "Error: " + fmt.Sprintf(MetricInvalidOperationMsg, e.MetricName, e.MetricType, e.MetricOperation)
-	return err
+	return withCause(err, e.Cause)
+}
+
+// Unwrap returns the underlying cause
+func (e MetricInvalidOperation) Unwrap() error {
+	return e.Cause
+}
+
+// Is matches the ErrMetricInvalidOperation sentinel
+func (e MetricInvalidOperation) Is(target error) bool {
+	return target == ErrMetricInvalidOperation
+}
+
+// Code returns CodeMetricInvalidOperation
+func (e MetricInvalidOperation) Code() Code {
+	return CodeMetricInvalidOperation
 }
 
 // ValueAssertionInvalid implements the error interface
 func (e ValueAssertionInvalid) Error() string {
 	err := "Error: " + fmt.Sprintf(ValueAssertionInvalidMsg, e.Value, e.ExpectedType)
-	return err
+	return withCause(err, e.Cause)
+}
+
+// Unwrap returns the underlying cause
+func (e ValueAssertionInvalid) Unwrap() error {
+	return e.Cause
+}
+
+// Is matches the ErrValueAssertionInvalid sentinel
+func (e ValueAssertionInvalid) Is(target error) bool {
+	return target == ErrValueAssertionInvalid
+}
+
+// Code returns CodeValueAssertionInvalid
+func (e ValueAssertionInvalid) Code() Code {
+	return CodeValueAssertionInvalid
+}
+
+// CheckpointInvalid implements the error interface
+func (e CheckpointInvalid) Error() string {
+	err := "Error: " + fmt.Sprintf(CheckpointInvalidMsg, e.Path, e.Reason)
+	return withCause(err, e.Cause)
+}
+
+// Unwrap returns the underlying cause
+func (e CheckpointInvalid) Unwrap() error {
+	return e.Cause
+}
+
+// Is matches the ErrCheckpointInvalid sentinel
+func (e CheckpointInvalid) Is(target error) bool {
+	return target == ErrCheckpointInvalid
+}
+
+// Code returns CodeCheckpointInvalid
+func (e CheckpointInvalid) Code() Code {
+	return CodeCheckpointInvalid
+}
+
+// SchemaInvalid implements the error interface
+func (e SchemaInvalid) Error() string {
+	err := "Error: " + fmt.Sprintf(SchemaInvalidMsg, e.Path, e.Field, e.Reason)
+	return withCause(err, e.Cause)
+}
+
+// Unwrap returns the underlying cause
+func (e SchemaInvalid) Unwrap() error {
+	return e.Cause
+}
+
+// Is matches the ErrSchemaInvalid sentinel
+func (e SchemaInvalid) Is(target error) bool {
+	return target == ErrSchemaInvalid
+}
+
+// Code returns CodeSchemaInvalid
+func (e SchemaInvalid) Code() Code {
+	return CodeSchemaInvalid
+}
+
+// RuleInvalid implements the error interface
+func (e RuleInvalid) Error() string {
+	err := "Error: " + fmt.Sprintf(RuleInvalidMsg, e.Rule, e.Expr, e.Reason)
+	return withCause(err, e.Cause)
+}
+
+// Unwrap returns the underlying cause
+func (e RuleInvalid) Unwrap() error {
+	return e.Cause
+}
+
+// Is matches the ErrRuleInvalid sentinel
+func (e RuleInvalid) Is(target error) bool {
+	return target == ErrRuleInvalid
+}
+
+// Code returns CodeRuleInvalid
+func (e RuleInvalid) Code() Code {
+	return CodeRuleInvalid
+}
+
+// CardinalityExceeded implements the error interface
+func (e CardinalityExceeded) Error() string {
+	err := "Error: " + fmt.Sprintf(CardinalityExceededMsg, e.MetricName, e.Limit, e.Overflow)
+	if e.Overflow == "" {
+		err = "Error: " + fmt.Sprintf(CardinalityRejectedMsg, e.MetricName, e.Limit)
+	}
+	return withCause(err, e.Cause)
+}
+
+// Unwrap returns the underlying cause
+func (e CardinalityExceeded) Unwrap() error {
+	return e.Cause
+}
+
+// Is matches the ErrCardinalityExceeded sentinel
+func (e CardinalityExceeded) Is(target error) bool {
+	return target == ErrCardinalityExceeded
+}
+
+// Code returns CodeCardinalityExceeded
+func (e CardinalityExceeded) Code() Code {
+	return CodeCardinalityExceeded
 }
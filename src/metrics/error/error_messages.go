@@ -9,10 +9,16 @@
 
 package error
 
-var (
+// Format of the error messages, kept constant so they are stable
+const (
 	MetricNotFoundMsg         = "Metric was not found | name=%s |"
 	MetricInvalidTypeMsg      = "Metric does not match with value to update | name=%s, type=%s |"
 	MetricInvalidOperationMsg = "Metric does not support operation | name=%s, type=%s, operation=%s |"
 	CounterNotFoundMsg        = "Counter was not found | name=%s |"
 	ValueAssertionInvalidMsg  = "Metric data could not be asserted | value=%v, type=%s |"
+	CheckpointInvalidMsg      = "Checkpoint could not be restored | path=%s, reason=%s |"
+	SchemaInvalidMsg          = "Metric schema is invalid | path=%s, field=%s, reason=%s |"
+	RuleInvalidMsg            = "Alert rule is invalid | rule=%s, expr=%s, reason=%s |"
+	CardinalityExceededMsg    = "Metric cardinality limit was exceeded | name=%s, limit=%d, overflow=%s |"
+	CardinalityRejectedMsg    = "Metric cardinality limit was exceeded | name=%s, limit=%d, rejected |"
 )
@@ -0,0 +1,107 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FlameWeight Statistic used as the width of the frames of a flame graph
+type FlameWeight int
+
+// Available flame graph weights
+const (
+	// FlameTime Time of the calls (ms)
+	FlameTime FlameWeight = iota
+	// FlameAllocBytes Bytes allocated by the calls, approximately (see
+	// FunctionTracerMetricsDTO.AllocBytes)
+	FlameAllocBytes
+	// FlameAllocObjects Objects allocated by the calls, approximately
+	FlameAllocObjects
+)
+
+var flameWeightsMap = map[string]FlameWeight{
+	"time":    FlameTime,
+	"bytes":   FlameAllocBytes,
+	"objects": FlameAllocObjects,
+}
+
+// ParseFlameWeight Get a FlameWeight from its name: time, bytes or objects
+func ParseFlameWeight(name string) (FlameWeight, error) {
+	weight, ok := flameWeightsMap[name]
+	if !ok {
+		return FlameTime, fmt.Errorf("Unknown flame weight |name=%s|, expected time, bytes or objects", name)
+	}
+	return weight, nil
+}
+
+// GetFlameValue Get the value of a call (including its children) for a weight
+func GetFlameValue(node *FunctionTracerMetricsDTO, weight FlameWeight) uint64 {
+	switch weight {
+	case FlameAllocBytes:
+		return node.AllocBytes
+	case FlameAllocObjects:
+		return node.AllocObjects
+	default:
+		if node.TotalTimeMs < 0 {
+			return 0
+		}
+		return uint64(node.TotalTimeMs)
+	}
+}
+
+// GetFoldedStacks Export a tree in the folded stacks format used by flame
+// graph tools (ex. flamegraph.pl, speedscope or pprof converters)
+//
+// Every line holds a call path, with the functions separated by ';' and
+// without call ID suffixes, followed by the self value of its calls (their
+// value minus their children's). Identical paths are merged and lines are
+// sorted by path. The root itself is not part of the paths.
+//
+// tree Tree returned by FunctionTracer.GetFunctionTracerMetrics
+// weight Statistic used as the value of the calls
+func GetFoldedStacks(tree *FunctionTracerMetricsDTO, weight FlameWeight) string {
+	stacks := map[string]uint64{}
+	for _, call := range tree.Children {
+		foldStacks(stacks, call, "", weight)
+	}
+
+	paths := make([]string, 0, len(stacks))
+	for path := range stacks {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	builder := &strings.Builder{}
+	for _, path := range paths {
+		fmt.Fprintf(builder, "%s %d\n", path, stacks[path])
+	}
+	return builder.String()
+}
+
+// foldStacks Add the self value of a call (and its children) to the stacks
+func foldStacks(stacks map[string]uint64, node *FunctionTracerMetricsDTO, prefix string, weight FlameWeight) {
+	path := prefix + GetName(node.Function)
+
+	// Goroutines started by a call may outlive it, the self value is
+	// clamped at 0 rather than underflowing
+	value := GetFlameValue(node, weight)
+	childrenValue := uint64(0)
+	for _, child := range node.Children {
+		childrenValue += GetFlameValue(child, weight)
+		foldStacks(stacks, child, path+";", weight)
+	}
+	if value > childrenValue {
+		stacks[path] += value - childrenValue
+	}
+}
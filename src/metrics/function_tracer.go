@@ -11,12 +11,32 @@
 package metrics
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"math"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 	"strings"
+
+	"metrics/clock"
+	errWrap "metrics/error"
+	metricTypes "metrics/metrictypes"
 )
 
+// Default number of distinct error messages kept per node
+const defaultMaxErrorMessages = 5
+
+// OverflowFunction Node the calls are aggregated in once the node limit of
+// the tracer is reached
+const OverflowFunction = "overflow()"
+
+// Maximum number of distinct error messages kept by the overflow node, which
+// aggregates any number of calls
+const maxOverflowErrorMessages = 100
+
 // Metrics DTO
 type FunctionTracerMetricsDTO struct {
 	Parent        string
@@ -26,10 +46,54 @@ type FunctionTracerMetricsDTO struct {
 	AverageTimeMs float64
 	LowerCeiling  int
 	HigherCeiling int
+	ErrorCount    int
+	ErrorRate     float64
+	Errors        []*FunctionErrorDTO
+	PanicCount    int
+	PanicValue    string
+	PanicStack    string
+	StartTime     time.Time
+	EndTime       time.Time
+	// Calls ended by the panic of a traced callee, counted on the callee
+	UnwoundCount int
+	// Heap allocations made during the call (including its children), only
+	// set when allocation tracking is enabled. Approximate: process-wide
+	// deltas of the runtime counters, which advance a span at a time
+	AllocBytes   uint64
+	AllocObjects uint64
+
+	// Only set on the calls made straight from the root
+	CriticalPath *CriticalPathDTO `json:",omitempty"`
 
 	Children []*FunctionTracerMetricsDTO
 }
 
+// FunctionErrorDTO One entry of the top-N error message table of a node
+type FunctionErrorDTO struct {
+	Type    string
+	Message string
+	Count   int
+}
+
+// FunctionOutcome Result of a traced function call
+type FunctionOutcome struct {
+	// Err Error returned by the traced function, nil on success
+	Err error
+	// Panic Value the traced function panicked with, nil if it did not panic
+	Panic interface{}
+	// PanicStack Stack trace of the panic
+	PanicStack string
+	// Unwound Whether the call was ended by the panic of a traced callee,
+	// whose outcome holds the panic
+	Unwound bool
+	// AllocBytes Bytes allocated on the heap during the call, approximately
+	// (see AllocBytes of FunctionTracerMetricsDTO)
+	AllocBytes uint64
+	// AllocObjects Objects allocated on the heap during the call,
+	// approximately
+	AllocObjects uint64
+}
+
 // FunctionTracer maintains metrics for function calls
 // ['fn_1']
 //    +-- calls
@@ -44,19 +108,44 @@ type FunctionTracer struct {
 	sync.Mutex
 	root string
 	metrics map[string]FunctionTracerMetricsDTO
+	maxErrorMessages int
+
+	// Clock timing the calls (a *tracerClock), read without the lock since
+	// every traced call gets its start time from it
+	clock atomic.Value
+
+	// Sliding windows of the call durations (ms) of every function, only
+	// kept when windowSpans is set
+	windowSpans []time.Duration
+	windows     map[string]*metricTypes.Window
+
+	// Maximum number of nodes of the tree (0 for no limit), the calls
+	// beyond it are aggregated in the overflow node
+	maxNodes      int
+	nodes         int
+	overflow      *FunctionTracerMetricsDTO
+	rejectedCalls int
+}
+
+// tracerClock Clock published by the FunctionTracer
+type tracerClock struct {
+	clock clock.Clock
 }
 
 
 // Utility functions
 
 // GetSuffix gets the suffix of a function name 
+//
+// The call ID suffix follows the last parenthesis so that method names
+// (ex. pkg.(*Type).Method()) are not cut at the receiver
 func GetSuffix(functionName string) (suffix string){
-	return functionName[strings.Index(functionName,")")+1:]
+	return functionName[strings.LastIndex(functionName,")")+1:]
 }
 
-// GetSuffix gets the suffix of a function name 
+// GetName gets the name of a function without its suffix
 func GetName(functionName string) (suffix string){
-	return functionName[:strings.Index(functionName,")")+1]
+	return functionName[:strings.LastIndex(functionName,")")+1]
 }
 // NewMetricSet returns a new MetricSet instance
 //
@@ -69,9 +158,33 @@ func GetName(functionName string) (suffix string){
 // for every traced function
 func NewFunctionTracer() *FunctionTracer {
 	functionTracerMetrics := make(map[string]FunctionTracerMetricsDTO)
-	return &FunctionTracer{
+	ft := &FunctionTracer{
 		Mutex:   sync.Mutex{},
 		metrics: functionTracerMetrics,
+		maxErrorMessages: defaultMaxErrorMessages,
+		windows: make(map[string]*metricTypes.Window),
+	}
+	ft.clock.Store(&tracerClock{clock: clock.Real})
+	return ft
+}
+
+// getErrorType Get the type name of the error that caused a failure
+//
+// This is the first metrics error (ex. error.MetricNotFound) in the chain or
+// else the innermost error, so wrapped errors (ex. fmt.Errorf("...: %w", err))
+// are reported with the type of the actual cause
+func getErrorType(err error) string {
+	var coded interface{ Code() errWrap.Code }
+	if errors.As(err, &coded) {
+		return fmt.Sprintf("%T", coded)
+	}
+
+	for {
+		cause := errors.Unwrap(err)
+		if cause == nil {
+			return fmt.Sprintf("%T", err)
+		}
+		err = cause
 	}
 }
 
@@ -83,19 +196,42 @@ func NewFunctionTracer() *FunctionTracer {
 // functionName Name of the traced function
 // start Function call starting time
 func (ft *FunctionTracer) IncreaseFunctionTracer(parentFunction string, functionName string, start time.Time) {
+	ft.IncreaseFunctionTracerOutcome(parentFunction, functionName, start, FunctionOutcome{})
+}
+
+// IncreaseFunctionTracerOutcome adds a new function trace metric along with
+// the outcome of the call
+//
+// parentFunction Name of the caller of the traced function
+// functionName Name of the traced function
+// start Function call starting time
+// outcome Result of the call (ex. the returned error)
+func (ft *FunctionTracer) IncreaseFunctionTracerOutcome(parentFunction string, functionName string, start time.Time,
+	outcome FunctionOutcome) {
 	ft.Lock()
 	defer ft.Unlock()
 
 	parentFunctionName := GetName(parentFunction)
 	// This function will be called on a defer so the start time is calculated
-	// during its deferral and time.Now() will be the ending time when it actually
+	// during its deferral and now will be the ending time when it actually
 	// gets executed
-	functionTimeMs := time.Now().Sub(start).Milliseconds()
+	end := ft.getClock().Now()
+	functionTimeMs := end.Sub(start).Milliseconds()
 
 	if len(ft.metrics) == 0{
 		ft.root = ft.root + GetSuffix(parentFunctionName) //Set the suffix of the root
 	}
 
+	// Every call adds nodes (the call IDs are unique), so past the limit the
+	// calls are only aggregated
+	if ft.maxNodes > 0 && ft.nodes >= ft.maxNodes {
+		ft.addOverflowCall(functionName, start, end, outcome)
+		if len(ft.windowSpans) > 0 {
+			ft.observeWindow(GetName(functionName), end.Sub(start))
+		}
+		return
+	}
+
 	if _, ok := ft.metrics[parentFunctionName]; !ok{
 		newFunctionMetrics := FunctionTracerMetricsDTO{
 			Parent:        parentFunctionName,
@@ -105,9 +241,11 @@ func (ft *FunctionTracer) IncreaseFunctionTracer(parentFunction string, function
 			AverageTimeMs: float64(0.0),
 			LowerCeiling:  int(math.MaxInt32),
 			HigherCeiling: int(0),
+			Errors:        []*FunctionErrorDTO{},
 			Children: []*FunctionTracerMetricsDTO{},
 		}
 		ft.metrics[parentFunctionName] = newFunctionMetrics
+		ft.nodes++
 	}
 
 	// Check to see if the traced function already exists in the metrics map
@@ -121,11 +259,14 @@ func (ft *FunctionTracer) IncreaseFunctionTracer(parentFunction string, function
 			AverageTimeMs: float64(0.0),
 			LowerCeiling:  int(math.MaxInt32),
 			HigherCeiling: int(0),
+			Errors:        []*FunctionErrorDTO{},
 			Children: []*FunctionTracerMetricsDTO{},
 		}
 	// Time to update the metrics
 	//functionMetrics := ft.metrics[parentFunctionName].Children[functionName] // Silly golang won't allow changing the struct directly...
 
+	newFunctionMetrics.StartTime = start
+	newFunctionMetrics.EndTime = end
 	newFunctionMetrics.Calls += 1
 	newFunctionMetrics.TotalTimeMs += int(functionTimeMs)
 	// The average is calculated on the fly when returning the metrics
@@ -135,11 +276,141 @@ func (ft *FunctionTracer) IncreaseFunctionTracer(parentFunction string, function
 	if functionTimeMs > int64(newFunctionMetrics.HigherCeiling) {
 		newFunctionMetrics.HigherCeiling = int(functionTimeMs)
 	}
+	if outcome.Err != nil {
+		newFunctionMetrics.ErrorCount += 1
+		newFunctionMetrics.Errors = append(newFunctionMetrics.Errors, &FunctionErrorDTO{
+			Type:    getErrorType(outcome.Err),
+			Message: outcome.Err.Error(),
+			Count:   1,
+		})
+	}
+	newFunctionMetrics.ErrorRate = getErrorRate(newFunctionMetrics.ErrorCount, newFunctionMetrics.Calls)
+	newFunctionMetrics.AllocBytes += outcome.AllocBytes
+	newFunctionMetrics.AllocObjects += outcome.AllocObjects
+	if outcome.Panic != nil {
+		newFunctionMetrics.PanicCount += 1
+		newFunctionMetrics.PanicValue = fmt.Sprint(outcome.Panic)
+		newFunctionMetrics.PanicStack = outcome.PanicStack
+	}
+	if outcome.Unwound {
+		newFunctionMetrics.UnwoundCount += 1
+	}
 
 	// Need to replace the map struct here... clunky but required in golang
 	children := ft.metrics[parentFunctionName] 
 	children.Children = append(children.Children,newFunctionMetrics)
 	ft.metrics[parentFunctionName] = children
+	ft.nodes++
+
+	if len(ft.windowSpans) > 0 {
+		ft.observeWindow(GetName(functionName), end.Sub(start))
+	}
+}
+
+// addOverflowCall Aggregate a call in the overflow node, a child of the root
+// added with the first call past the node limit, the lock must be held
+//
+// functionName Name of the traced function
+// start Function call starting time
+// end Function call ending time
+// outcome Result of the call
+func (ft *FunctionTracer) addOverflowCall(functionName string, start time.Time, end time.Time, outcome FunctionOutcome) {
+	if ft.overflow == nil {
+		ft.overflow = &FunctionTracerMetricsDTO{
+			Parent:       ft.root,
+			Function:     OverflowFunction,
+			LowerCeiling: int(math.MaxInt32),
+			Errors:       []*FunctionErrorDTO{},
+			Children:     []*FunctionTracerMetricsDTO{},
+			StartTime:    start,
+		}
+		root, ok := ft.metrics[ft.root]
+		if !ok {
+			root = FunctionTracerMetricsDTO{
+				Parent:       ft.root,
+				Function:     ft.root,
+				LowerCeiling: int(math.MaxInt32),
+				Errors:       []*FunctionErrorDTO{},
+			}
+		}
+		root.Children = append(root.Children, ft.overflow)
+		ft.metrics[ft.root] = root
+		log.Print(errWrap.CardinalityExceeded{MetricName: functionName, Limit: ft.maxNodes, Overflow: OverflowFunction})
+	}
+
+	functionTimeMs := int(end.Sub(start).Milliseconds())
+	node := ft.overflow
+	node.EndTime = end
+	node.Calls++
+	node.TotalTimeMs += functionTimeMs
+	if functionTimeMs < node.LowerCeiling {
+		node.LowerCeiling = functionTimeMs
+	}
+	if functionTimeMs > node.HigherCeiling {
+		node.HigherCeiling = functionTimeMs
+	}
+	if outcome.Err != nil {
+		node.ErrorCount++
+		ft.addOverflowError(outcome.Err)
+	}
+	node.ErrorRate = getErrorRate(node.ErrorCount, node.Calls)
+	node.AverageTimeMs = getAverage(node.TotalTimeMs, node.Calls)
+	node.AllocBytes += outcome.AllocBytes
+	node.AllocObjects += outcome.AllocObjects
+	if outcome.Panic != nil {
+		node.PanicCount++
+	}
+	if outcome.Unwound {
+		node.UnwoundCount++
+	}
+	ft.rejectedCalls++
+}
+
+// addOverflowError Count an error in the table of the overflow node, the
+// lock must be held
+//
+// The errors are merged by type and message, the messages past
+// maxOverflowErrorMessages are only counted in ErrorCount.
+//
+// err Error returned by the call
+func (ft *FunctionTracer) addOverflowError(err error) {
+	errorType, message := getErrorType(err), err.Error()
+	for _, functionError := range ft.overflow.Errors {
+		if functionError.Type == errorType && functionError.Message == message {
+			functionError.Count++
+			return
+		}
+	}
+	if len(ft.overflow.Errors) < maxOverflowErrorMessages {
+		ft.overflow.Errors = append(ft.overflow.Errors, &FunctionErrorDTO{Type: errorType, Message: message, Count: 1})
+	}
+}
+
+// copyOverflow Copy the overflow node along with its error table, which are
+// updated in place by the calls past the node limit, the lock must be held
+func (ft *FunctionTracer) copyOverflow() *FunctionTracerMetricsDTO {
+	overflow := *ft.overflow
+	overflow.Errors = make([]*FunctionErrorDTO, 0, len(ft.overflow.Errors))
+	for _, functionError := range ft.overflow.Errors {
+		errorCopy := *functionError
+		overflow.Errors = append(overflow.Errors, &errorCopy)
+	}
+	overflow.Children = []*FunctionTracerMetricsDTO{}
+	return &overflow
+}
+
+// observeWindow Add a call duration to the sliding windows of a function,
+// the lock must be held
+//
+// functionName Name of the function, without the call ID suffix
+// duration Duration of the call
+func (ft *FunctionTracer) observeWindow(functionName string, duration time.Duration) {
+	window, ok := ft.windows[functionName]
+	if !ok {
+		window = metricTypes.NewWindow(ft.windowSpans, metricTypes.DefaultLatencyBuckets, ft.getClock())
+		ft.windows[functionName] = window
+	}
+	window.Observe(float64(duration) / float64(time.Millisecond))
 }
 
 // getAverage Calculate the average
@@ -151,11 +422,77 @@ func getAverage(total int, count int) float64 {
 	return float64(total) / float64(count)
 }
 
+// getErrorRate Calculate the ratio of failed calls
+func getErrorRate(errorCount int, calls int) float64 {
+	if calls <= 0 {
+		return 0
+	}
+
+	return float64(errorCount) / float64(calls)
+}
+
+// mergeErrors Merge the error tables of a node into an aggregated table
+//
+// errorTable Aggregated table indexed by type and message
+// node Node whose errors (and its children's) are merged
+// returns the number of calls and failed calls found in the subtree
+func mergeErrors(errorTable map[FunctionErrorDTO]int, node *FunctionTracerMetricsDTO) (calls int, errorCount int) {
+	calls = node.Calls
+	errorCount = node.ErrorCount
+	for _, functionError := range node.Errors {
+		errorTable[FunctionErrorDTO{Type: functionError.Type, Message: functionError.Message}] += functionError.Count
+	}
+	for _, child := range node.Children {
+		childCalls, childErrorCount := mergeErrors(errorTable, child)
+		calls += childCalls
+		errorCount += childErrorCount
+	}
+	return calls, errorCount
+}
+
+// countPanics Count the panicked calls in a subtree
+func countPanics(node *FunctionTracerMetricsDTO) int {
+	panicCount := node.PanicCount
+	for _, child := range node.Children {
+		panicCount += countPanics(child)
+	}
+	return panicCount
+}
+
+// getTopErrors Get the top-N most frequent errors of an aggregated table
+func getTopErrors(errorTable map[FunctionErrorDTO]int, maxErrorMessages int) []*FunctionErrorDTO {
+	topErrors := []*FunctionErrorDTO{}
+	for functionError, count := range errorTable {
+		topErrors = append(topErrors, &FunctionErrorDTO{
+			Type:    functionError.Type,
+			Message: functionError.Message,
+			Count:   count,
+		})
+	}
+	sort.Slice(topErrors, func(i, j int) bool {
+		if topErrors[i].Count != topErrors[j].Count {
+			return topErrors[i].Count > topErrors[j].Count
+		}
+		return topErrors[i].Message < topErrors[j].Message
+	})
+	if len(topErrors) > maxErrorMessages {
+		topErrors = topErrors[:maxErrorMessages]
+	}
+	return topErrors
+}
+
 func (ft *FunctionTracer) GetTree (root string,functionCall string) []*FunctionTracerMetricsDTO{
 	
 	functionChildren := []*FunctionTracerMetricsDTO{}
 	if child,ok := ft.metrics[root]; ok{ //Check to see if root function made calls
 		for _,metrics := range child.Children{ //Iterate through all the calls
+			// The nodes are copied, so the tree can be read without the lock
+			if metrics == ft.overflow {
+				metrics = ft.copyOverflow()
+			} else {
+				node := *metrics
+				metrics = &node
+			}
 			childInMap := GetName(metrics.Function) + GetSuffix(metrics.Parent)
 			if(functionCall == ft.root || GetSuffix(metrics.Function) == GetSuffix(functionCall) ){ //Check to see if the call was made from the same root call	
 				functionChildren = append(functionChildren,metrics)
@@ -185,9 +522,25 @@ func (ft *FunctionTracer) GetFunctionTracerMetrics() FunctionTracerMetricsDTO {
 		AverageTimeMs: float64(0.0),
 		LowerCeiling:  int(math.MaxInt32),
 		HigherCeiling: int(0),
+		Errors:        []*FunctionErrorDTO{},
 		Children: []*FunctionTracerMetricsDTO{},
 	}
 	tree.Children=ft.GetTree(ft.root,ft.root)
+	for _, call := range tree.Children {
+		call.CriticalPath = GetCriticalPath(call)
+	}
+
+	// The root summarizes the outcome of every call in the tree
+	errorTable := make(map[FunctionErrorDTO]int)
+	calls, errorCount := mergeErrors(errorTable, &tree)
+	tree.ErrorCount = errorCount
+	tree.ErrorRate = getErrorRate(errorCount, calls)
+	tree.Errors = getTopErrors(errorTable, ft.maxErrorMessages)
+	tree.PanicCount = countPanics(&tree)
+	for _, call := range tree.Children {
+		tree.AllocBytes += call.AllocBytes
+		tree.AllocObjects += call.AllocObjects
+	}
 	
 	//Time to build the tree
 	for _, metrics := range ft.metrics {
@@ -214,6 +567,97 @@ func (ft *FunctionTracer) Clear() {
 	for function := range ft.metrics {
 		delete(ft.metrics, function)
 	}
+	ft.windows = make(map[string]*metricTypes.Window)
+	ft.nodes = 0
+	ft.overflow = nil
+	ft.rejectedCalls = 0
+}
+
+// SetMaxNodes Set the maximum number of nodes of the call tree
+//
+// Every traced call adds a node, so long running programs that are never
+// cleared should set a limit. The calls past it are aggregated in a single
+// "overflow()" child of the root, without their errors and children, and a
+// warning is logged.
+//
+// maxNodes Maximum number of nodes, 0 for no limit
+func (ft *FunctionTracer) SetMaxNodes(maxNodes int) {
+	ft.Lock()
+	defer ft.Unlock()
+
+	ft.maxNodes = maxNodes
+}
+
+// GetRejectedCalls Get the number of calls aggregated in the overflow node
+// since the tracer was cleared
+func (ft *FunctionTracer) GetRejectedCalls() int {
+	ft.Lock()
+	defer ft.Unlock()
+
+	return ft.rejectedCalls
+}
+
+// SetWindows Set the sliding windows kept for every function, replacing
+// the current ones
+//
+// Every window reports the calls, their rate and the p50/p90/p99 of their
+// duration (ms) over its span, independently of the tree (ex. the p99
+// latency of every function over the last 5m). They are emptied by Clear.
+//
+// spans Lengths of the windows, none to stop keeping windows
+func (ft *FunctionTracer) SetWindows(spans ...time.Duration) {
+	ft.Lock()
+	defer ft.Unlock()
+
+	ft.windowSpans = append([]time.Duration{}, spans...)
+	ft.windows = make(map[string]*metricTypes.Window)
+}
+
+// GetFunctionWindows Get the sliding windows of every traced function
+//
+// returns the windows (shortest span first) indexed by function name,
+// without call ID suffixes
+func (ft *FunctionTracer) GetFunctionWindows() map[string][]metricTypes.WindowSnapshot {
+	ft.Lock()
+	defer ft.Unlock()
+
+	functionWindows := make(map[string][]metricTypes.WindowSnapshot, len(ft.windows))
+	for functionName, window := range ft.windows {
+		functionWindows[functionName] = window.Snapshot()
+	}
+	return functionWindows
+}
+
+// SetMaxErrorMessages Set the size of the top-N error message table
+//
+// maxErrorMessages Number of distinct error messages reported
+func (ft *FunctionTracer) SetMaxErrorMessages(maxErrorMessages int) {
+	ft.Lock()
+	defer ft.Unlock()
+
+	ft.maxErrorMessages = maxErrorMessages
+}
+
+// SetClock Set the clock used to time the traced calls
+//
+// Tests can use a clock.Manual to get deterministic timings. The sliding
+// windows are emptied since they are timed by the clock too.
+func (ft *FunctionTracer) SetClock(clock clock.Clock) {
+	ft.Lock()
+	defer ft.Unlock()
+
+	ft.clock.Store(&tracerClock{clock: clock})
+	ft.windows = make(map[string]*metricTypes.Window)
+}
+
+// Now Get the current time of the tracer's clock, without taking its lock
+func (ft *FunctionTracer) Now() time.Time {
+	return ft.getClock().Now()
+}
+
+// getClock Get the clock timing the calls
+func (ft *FunctionTracer) getClock() clock.Clock {
+	return ft.clock.Load().(*tracerClock).clock
 }
 
 func (ft *FunctionTracer) SetRoot(root string) {
@@ -12,8 +12,14 @@ package metrics
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"metrics/clock"
+	errWrap "metrics/error"
 	metricTypes "metrics/metrictypes"
 )
 
@@ -27,6 +33,12 @@ const (
 	Fraction
 	String
 	Time
+	Gauge
+	Histogram
+	Meter
+	Window
+	Timer
+	Ratio
 )
 
 var metricCapabilitiesMap = map[string]MetricType{
@@ -35,14 +47,122 @@ var metricCapabilitiesMap = map[string]MetricType{
 	"Fraction":      Fraction,
 	"String":        String,
 	"Time":          Time,
+	"Gauge":         Gauge,
+	"Histogram":     Histogram,
+	"Meter":         Meter,
+	"Window":        Window,
+	"Timer":         Timer,
+	"Ratio":         Ratio,
+}
+
+// getMetricTypeName Get the name of a metric type, as used in the metric data
+func getMetricTypeName(metricType MetricType) string {
+	for name, value := range metricCapabilitiesMap {
+		if value == metricType {
+			return name
+		}
+	}
+	return "InvalidMetric"
+}
+
+// Values to declare Gauge and callback metrics in the map given to NewMetrics
+//
+// Ex. "queue": gometrics.GaugeFunc(func() float64 { return float64(len(queue)) })
+type (
+	// GaugeValue Initial value of a Gauge metric, ex. gometrics.GaugeValue(0)
+	GaugeValue = metricTypes.Gauge
+	// GaugeFunc Callback of a Gauge metric, called every time it is read
+	GaugeFunc = metricTypes.GaugeFunc
+	// CounterFunc Callback of a Counter metric, called every time it is read
+	CounterFunc = metricTypes.CounterFunc
+	// HistogramFunc Callback of a Histogram metric, called every time it is
+	// read
+	HistogramFunc = metricTypes.HistogramFunc
+	// HistogramValue Distribution read from a Histogram metric
+	HistogramValue = metricTypes.Histogram
+	// MeterValue Meter metric, returned by GetMeter to mark events without
+	// looking it up every time
+	MeterValue = metricTypes.Meter
+	// MeterSnapshot Count and rates read from a Meter metric
+	MeterSnapshot = metricTypes.MeterSnapshot
+	// WindowSnapshot Aggregate of one span read from a Window metric, which
+	// is read as a []WindowSnapshot
+	WindowSnapshot = metricTypes.WindowSnapshot
+	// TimerValue Timer metric, returned by GetTimer to record durations
+	// without looking it up every time
+	TimerValue = metricTypes.Timer
+	// TimerSnapshot Count, sum, min, max and distribution read from a Timer
+	// metric
+	TimerSnapshot = metricTypes.TimerSnapshot
+	// RatioValue Ratio metric, returned by GetRatio to count hits and misses
+	// without looking it up every time
+	RatioValue = metricTypes.Ratio
+	// RatioSnapshot Numerator, denominator and ratio read from a Ratio
+	// metric, overall and per window
+	RatioSnapshot = metricTypes.RatioSnapshot
+	// RatioWindow Numerator, denominator and ratio of a Ratio metric during
+	// one span
+	RatioWindow = metricTypes.RatioWindow
+)
+
+// DefaultLatencyBuckets Histogram buckets (ms) of latency Window metrics
+var DefaultLatencyBuckets = metricTypes.DefaultLatencyBuckets
+
+// NewHistogram returns an empty HistogramValue
+//
+// buckets Upper bounds of the buckets, in increasing order
+func NewHistogram(buckets []float64) HistogramValue {
+	return metricTypes.NewHistogram(buckets)
 }
 
 // Metrics is a struct to keep record of metrics
 type Metrics struct {
-	mutex      sync.Mutex
+	// The lock and options are shared by the copies of the Metrics, as the
+	// metric data is
+	mutex      *sync.Mutex
+	options    *metricsOptions
 	metricData metricTypes.MetricSet
 }
 
+// metricsOptions Settings of a Metrics, guarded by its lock
+type metricsOptions struct {
+	clock clock.Clock
+	// Help and unit of the metrics, by metric name without labels
+	descriptions map[string]description
+	// Age after which the Time metrics are stale, by metric name
+	staleAfter map[string]time.Duration
+	// Only set for Metrics created with NewMetricsWithCheckpoint
+	checkpoint *checkpointer
+	// Subscriptions to the changes of the metrics
+	subscriptions []*Subscription
+	// Limits of the series created at runtime
+	limits CardinalityLimits
+	// Number of series folded into an overflow series or rejected, updated
+	// atomically
+	rejectedSeries uint64
+	// Whether MaxSeries is rejecting the series of new metrics, reported
+	// once
+	rejecting bool
+	// TTLs of the series, by series or metric name
+	ttls     map[string]time.Duration
+	onExpire []func(metricName string, value interface{})
+	// Last update of the series with a TTL, by series name, as a *int64 of
+	// unix nanoseconds updated atomically by the updates that do not take
+	// the lock. Series are only added and removed with the lock held.
+	lastUpdates sync.Map
+	// Copy of the TTLs and clock (a *ttlState) read by the updates that do
+	// not take the lock, published when they change
+	published atomic.Value
+	// Number of series removed by an expiry, updated atomically
+	expiredSeries uint64
+}
+
+// description Help text and unit of a metric
+type description struct {
+	help string
+	unit string
+}
+
 // NewMetrics returns a new Metrics struct with the specified metrics
 //
 // metrics Map that contains the name and type of the metrics to be added
@@ -55,10 +175,37 @@ func NewMetrics(metrics map[string]interface{}) Metrics {
 
 	// Create and return metric structure
 	return Metrics{
+		mutex: &sync.Mutex{},
+		options: &metricsOptions{
+			clock:        clock.Real,
+			descriptions: map[string]description{},
+			staleAfter:   map[string]time.Duration{},
+			ttls:         map[string]time.Duration{},
+		},
 		metricData: metricSet,
 	}
 }
 
+// SetClock sets the clock of the Metrics, used for the snapshot times and by
+// the time-based metrics added afterwards without a clock of their own
+//
+// Tests can use a clock.Manual to get deterministic values
+func (metric Metrics) SetClock(clock clock.Clock) {
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+
+	metric.options.clock = clock
+	metric.publishTTLs()
+}
+
+// Now returns the current time of the clock of the Metrics
+func (metric Metrics) Now() time.Time {
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+
+	return metric.options.clock.Now()
+}
+
 // IncreaseMetricValue increases the value of the specified metric
 //
 // metricName Name of the counter to increase value
@@ -67,7 +214,9 @@ func (metric Metrics) IncreaseMetricValue(metricName string, increment interface
 	metric.mutex.Lock()
 	defer metric.mutex.Unlock()
 
-	return metric.metricData.IncreaseMetric(metricName, increment)
+	return metric.change(metricName, func() error {
+		return metric.metricData.IncreaseMetric(metricName, increment)
+	})
 }
 
 // DecreaseMetricValue decreases the value of the specified metric
@@ -78,17 +227,20 @@ func (metric Metrics) DecreaseMetricValue(metricName string, decrement interface
 	metric.mutex.Lock()
 	defer metric.mutex.Unlock()
 
-	return metric.metricData.DecreaseMetric(metricName, decrement)
+	return metric.change(metricName, func() error {
+		return metric.metricData.DecreaseMetric(metricName, decrement)
+	})
 }
 
 // ReadMetric returns the value as a string of the specified metric
 //
+// Callbacks (ex. GaugeFunc) are called without holding the lock
+// of the Metrics, so they can read other metrics
+//
 // metricName Name of the metric to be read
 // returns error if specified metric does not exist
 func (metric Metrics) ReadMetric(metricName string) (interface{}, error) {
-	metric.mutex.Lock()
-	defer metric.mutex.Unlock()
-
+	metric.expireLazily(metricName)
 	value, err := metric.metricData.GetMetricValue(metricName)
 	return value, err
 }
@@ -96,16 +248,18 @@ func (metric Metrics) ReadMetric(metricName string) (interface{}, error) {
 // ReadMetricAsFloat64 returns the value as a float64 of the specified metric
 //
 // metricName Name of the metric to be read
-// returns error if specified metric does not exist
+// returns error if specified metric does not exist (wrapping MetricNotFound)
+// or is not a float64 (wrapping ValueAssertionInvalid)
 func (metric Metrics) ReadMetricAsFloat64(metricName string) (float64, error) {
 	iValue, err := metric.ReadMetric(metricName)
 	if err != nil {
-		return 0.0, fmt.Errorf("Unable to read metric |name=%s, value=%v, error=%s", metricName, iValue, err)
+		return 0.0, fmt.Errorf("Unable to read metric |name=%s, value=%v, error=%w", metricName, iValue, err)
 	}
 
 	value, ok := iValue.(float64)
 	if !ok {
-		return 0.0, fmt.Errorf("Unable to cast metric |name=%s, value=%v, type=float64", metricName, iValue)
+		return 0.0, fmt.Errorf("Unable to cast metric |name=%s|: %w", metricName,
+			errWrap.ValueAssertionInvalid{Value: iValue, ExpectedType: "float64"})
 	}
 
 	return value, err
@@ -120,7 +274,399 @@ func (metric Metrics) SetMetric(metricName string, value interface{}) error {
 	metric.mutex.Lock()
 	defer metric.mutex.Unlock()
 
-	return metric.metricData.SetMetricValue(metricName, value)
+	return metric.change(metricName, func() error {
+		return metric.metricData.SetMetricValue(metricName, value)
+	})
+}
+
+// AddMetric adds a metric with its initial value (ex. 0 for a Counter), as
+// given to NewMetrics, unless a metric with the same name already exists
+//
+// Metrics beyond the cardinality limits (see SetCardinalityLimits) are
+// folded into an overflow series instead, use AddSeries to get its name.
+//
+// metricName Name of the metric to be added
+// initialValue Initial value of the metric, its type sets the metric type
+// returns whether the metric was added
+func (metric Metrics) AddMetric(metricName string, initialValue interface{}) bool {
+	metric.mutex.Lock()
+	_, added, err := metric.addSeries(metricName, initialValue)
+	metric.mutex.Unlock()
+
+	metric.warnOverflow(err)
+	return added
+}
+
+// AddHistogram adds a Histogram metric, replacing any metric with the same
+// name
+//
+// Values are added with ObserveMetric and it is read as a HistogramValue.
+//
+// metricName Name of the metric to be added
+// buckets Upper bounds of the buckets, in increasing order (ex.
+// DefaultLatencyBuckets)
+func (metric Metrics) AddHistogram(metricName string, buckets []float64) {
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+
+	histogram := metricTypes.NewHistogram(buckets)
+	metric.change(metricName, func() error {
+		metric.metricData.AddMetric(metricName, &histogram)
+		return nil
+	})
+}
+
+// Describe sets the help text and unit of a metric, used by the exporters
+//
+// Labeled series share the description of their metric (ex. the one of
+// "requests" for `requests{method="GET"}`)
+//
+// metricName Name of the metric, without labels
+// help One line description of the metric
+// unit Unit of the values (ex. "seconds"), empty if it has none
+func (metric Metrics) Describe(metricName string, help string, unit string) {
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+
+	metric.options.descriptions[metricName] = description{help: help, unit: unit}
+}
+
+// GetDescription returns the help text and unit of a metric, set with
+// Describe
+//
+// metricName Name of the metric or of one of its labeled series
+// returns empty strings if the metric was not described
+func (metric Metrics) GetDescription(metricName string) (help string, unit string) {
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+
+	desc := metric.options.descriptions[metricTypes.FamilyName(metricName)]
+	return desc.help, desc.unit
+}
+
+// SeriesName returns the name of the series of a metric with the given
+// labels, ex. `requests{code="200",method="GET"}`
+//
+// Labels are sorted by name and quotes, backslashes and newlines in their
+// values are escaped
+//
+// metricName Name of the metric
+// labels Label names and values, the metric name is returned if empty
+func SeriesName(metricName string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return metricName
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var series strings.Builder
+	series.WriteString(metricName)
+	series.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			series.WriteByte(',')
+		}
+		fmt.Fprintf(&series, "%s=\"%s\"", name, labelEscaper.Replace(labels[name]))
+	}
+	series.WriteByte('}')
+	return series.String()
+}
+
+// labelEscaper Escapes the label values of series names
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// AddGaugeFunc adds a Gauge metric whose value is returned by a callback
+// every time it is read (ex. the length of a queue), replacing any metric
+// with the same name
+//
+// metricName Name of the metric to be added
+// callback Function returning the current value of the metric
+func (metric Metrics) AddGaugeFunc(metricName string, callback func() float64) {
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+
+	metric.change(metricName, func() error {
+		metric.metricData.AddMetric(metricName, GaugeFunc(callback))
+		return nil
+	})
+}
+
+// AddCounterFunc adds a Counter metric whose value is returned by a callback
+// every time it is read, replacing any metric with the same name
+//
+// metricName Name of the metric to be added
+// callback Function returning the current value of the metric
+func (metric Metrics) AddCounterFunc(metricName string, callback func() int) {
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+
+	metric.change(metricName, func() error {
+		metric.metricData.AddMetric(metricName, CounterFunc(callback))
+		return nil
+	})
+}
+
+// AddHistogramFunc adds a Histogram metric whose distribution is returned by
+// a callback every time it is read, replacing any metric with the same name
+//
+// metricName Name of the metric to be added
+// callback Function returning the current distribution of the metric
+func (metric Metrics) AddHistogramFunc(metricName string, callback func() HistogramValue) {
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+
+	metric.change(metricName, func() error {
+		metric.metricData.AddMetric(metricName, HistogramFunc(callback))
+		return nil
+	})
+}
+
+// AddMeter adds a Meter metric, replacing any metric with the same name
+//
+// A Meter counts events and tracks their rate (events per second) as 1, 5
+// and 15 minute exponentially weighted moving averages and a mean rate. It
+// is read as a MeterSnapshot.
+//
+// metricName Name of the metric to be added
+// clock Clock used to compute the rates, the clock of the Metrics if nil
+func (metric Metrics) AddMeter(metricName string, clock clock.Clock) {
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+
+	if clock == nil {
+		clock = metric.options.clock
+	}
+	metric.change(metricName, func() error {
+		metric.metricData.AddMetric(metricName, metricTypes.NewMeter(clock))
+		return nil
+	})
+}
+
+// MarkMeter records events on a Meter metric
+//
+// Unlike IncreaseMetricValue (which also marks Meter metrics) it does not
+// take the lock of the Metrics, only the read lock of the metric data to
+// look the Meter up. Hot paths can use GetMeter to look it up once. With a
+// TTL (see SetTTL), only the first update of the series takes the lock.
+//
+// metricName Name of the Meter metric
+// count Number of events
+// returns error if specified metric does not exist or is not a Meter
+func (metric Metrics) MarkMeter(metricName string, count int) error {
+	if err := metric.metricData.MarkMeter(metricName, count); err != nil {
+		return err
+	}
+	metric.markUpdated(metricName)
+	return nil
+}
+
+// GetMeter returns a Meter metric, ex. to call meter.Mark(1) without looking
+// it up every time
+//
+// metricName Name of the Meter metric
+// returns error if specified metric does not exist or is not a Meter
+func (metric Metrics) GetMeter(metricName string) (*MeterValue, error) {
+	return metric.metricData.GetMeter(metricName)
+}
+
+// AddWindow adds a Window metric, replacing any metric with the same name
+//
+// A Window aggregates the values observed over sliding time windows (ex.
+// requests in the last 1m, 5m and 1h or the p99 latency over the last 5m)
+// backed by rotating buckets, so it does not need to be reset. It is read as
+// a []WindowSnapshot, one per span.
+//
+// metricName Name of the metric to be added
+// spans Lengths of the sliding windows
+// buckets Upper bounds of the histogram buckets used for percentiles (ex.
+// DefaultLatencyBuckets), nil for counters
+// clock Clock used to rotate the buckets, the clock of the Metrics if nil
+func (metric Metrics) AddWindow(metricName string, spans []time.Duration, buckets []float64, clock clock.Clock) {
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+
+	if clock == nil {
+		clock = metric.options.clock
+	}
+	metric.change(metricName, func() error {
+		metric.metricData.AddMetric(metricName, metricTypes.NewWindow(spans, buckets, clock))
+		return nil
+	})
+}
+
+// AddTimer adds a Timer metric, replacing any metric with the same name
+//
+// A Timer records durations (ex. of database queries) with their count, sum,
+// min, max and distribution. It is read as a TimerSnapshot.
+//
+// metricName Name of the metric to be added
+// buckets Upper bounds (ms) of the buckets of the distribution,
+// DefaultLatencyBuckets if nil
+// clock Clock used to time the durations, the clock of the Metrics if nil
+func (metric Metrics) AddTimer(metricName string, buckets []float64, clock clock.Clock) {
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+
+	if clock == nil {
+		clock = metric.options.clock
+	}
+	metric.change(metricName, func() error {
+		metric.metricData.AddMetric(metricName, metricTypes.NewTimer(buckets, clock))
+		return nil
+	})
+}
+
+// GetTimer returns a Timer metric, ex. to call timer.ObserveSince(start)
+// without looking it up every time
+//
+// metricName Name of the Timer metric
+// returns error if specified metric does not exist or is not a Timer
+func (metric Metrics) GetTimer(metricName string) (*TimerValue, error) {
+	return metric.metricData.GetTimer(metricName)
+}
+
+// Time starts timing a code block, the returned function records the
+// duration elapsed since in a Timer metric
+//
+//	defer globalMetrics.Time("db_query")()
+//
+// The Timer is added (with DefaultLatencyBuckets) if it does not exist,
+// within the cardinality limits. If a metric that is not a Timer has the
+// name, nothing is recorded.
+//
+// metricName Name of the Timer metric
+func (metric Metrics) Time(metricName string) func() {
+	timer, err := metric.metricData.GetTimer(metricName)
+	if err != nil {
+		metric.mutex.Lock()
+		series, _, overflowErr := metric.addSeries(metricName, metricTypes.NewTimer(nil, metric.options.clock))
+		metric.mutex.Unlock()
+		metric.warnOverflow(overflowErr)
+
+		if timer, err = metric.metricData.GetTimer(series); err != nil {
+			return func() {}
+		}
+	}
+	metric.markUpdated(metricName)
+	return timer.Start()
+}
+
+// ObserveDuration records a duration in a Timer metric
+//
+// IncreaseMetricValue also records time.Duration values in Timer metrics,
+// but takes the lock of the Metrics
+//
+// metricName Name of the Timer metric
+// duration Duration observed
+// returns error if specified metric does not exist or is not a Timer
+func (metric Metrics) ObserveDuration(metricName string, duration time.Duration) error {
+	timer, err := metric.metricData.GetTimer(metricName)
+	if err != nil {
+		return err
+	}
+	timer.Observe(duration)
+	metric.markUpdated(metricName)
+	return nil
+}
+
+// AddRatio adds a Ratio metric, replacing any metric with the same name
+//
+// A Ratio tracks a numerator and a denominator (ex. cache hits out of
+// lookups) updated together. It is read as a RatioSnapshot, with the ratio
+// overall and during the last spans.
+//
+// metricName Name of the metric to be added
+// spans Lengths of the sliding windows of the ratio, none if nil
+// clock Clock used to rotate the windows, the clock of the Metrics if nil
+func (metric Metrics) AddRatio(metricName string, spans []time.Duration, clock clock.Clock) {
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+
+	if clock == nil {
+		clock = metric.options.clock
+	}
+	metric.change(metricName, func() error {
+		metric.metricData.AddMetric(metricName, metricTypes.NewRatio(spans, clock))
+		return nil
+	})
+}
+
+// GetRatio returns a Ratio metric, ex. to call ratio.IncHit() without
+// looking it up every time
+//
+// metricName Name of the Ratio metric
+// returns error if specified metric does not exist or is not a Ratio
+func (metric Metrics) GetRatio(metricName string) (*RatioValue, error) {
+	return metric.metricData.GetRatio(metricName)
+}
+
+// IncHit adds 1 to the numerator and the denominator of a Ratio metric
+//
+// metricName Name of the Ratio metric
+// returns error if specified metric does not exist or is not a Ratio
+func (metric Metrics) IncHit(metricName string) error {
+	return metric.AddToRatio(metricName, 1, 1)
+}
+
+// IncMiss adds 1 to the denominator of a Ratio metric
+//
+// metricName Name of the Ratio metric
+// returns error if specified metric does not exist or is not a Ratio
+func (metric Metrics) IncMiss(metricName string) error {
+	return metric.AddToRatio(metricName, 0, 1)
+}
+
+// AddToRatio adds to the numerator and the denominator of a Ratio metric
+//
+// IncreaseMetricValue also counts hits (true) and misses (false) of Ratio
+// metrics, but takes the lock of the Metrics
+//
+// metricName Name of the Ratio metric
+// numerator Increment of the numerator (ex. successful requests)
+// denominator Increment of the denominator (ex. all the requests)
+// returns error if specified metric does not exist or is not a Ratio
+func (metric Metrics) AddToRatio(metricName string, numerator int, denominator int) error {
+	ratio, err := metric.metricData.GetRatio(metricName)
+	if err != nil {
+		return err
+	}
+	ratio.Add(numerator, denominator)
+	metric.markUpdated(metricName)
+	return nil
+}
+
+// ObserveMetric adds a value to a Window or Histogram metric
+//
+// IncreaseMetricValue also adds int (ex. counter increments) and float64
+// values to Window and Histogram metrics, but takes the lock of the Metrics.
+// ObserveMetric only takes it for Histogram metrics, to notify the
+// subscriptions.
+//
+// metricName Name of the Window or Histogram metric
+// value Value observed (ex. a latency)
+// returns error if specified metric does not exist or is not a Window or a
+// Histogram
+func (metric Metrics) ObserveMetric(metricName string, value float64) error {
+	// Histogram metrics are notified to the subscriptions, Window metrics
+	// are computed and only take their own lock
+	if metricCapabilitiesMap[metric.metricData.GetMetricType(metricName)] == Histogram {
+		metric.mutex.Lock()
+		defer metric.mutex.Unlock()
+
+		return metric.change(metricName, func() error {
+			return metric.metricData.ObserveMetric(metricName, value)
+		})
+	}
+
+	if err := metric.metricData.ObserveMetric(metricName, value); err != nil {
+		return err
+	}
+	metric.markUpdated(metricName)
+	return nil
 }
 
 // ResetMetric resets the value of the specified metric
@@ -131,7 +677,9 @@ func (metric Metrics) ResetMetric(metricName string) error {
 	metric.mutex.Lock()
 	defer metric.mutex.Unlock()
 
-	return metric.metricData.ResetMetric(metricName)
+	return metric.change(metricName, func() error {
+		return metric.metricData.ResetMetric(metricName)
+	})
 }
 
 // ResetMetric resets the value of all metrics
@@ -139,11 +687,12 @@ func (metric Metrics) ResetAllMetrics() {
 	metric.mutex.Lock()
 	defer metric.mutex.Unlock()
 
-	metric.metricData.ResetAllMetrics()
+	metric.changeAll(metric.metricData.ResetAllMetrics)
 }
 
 // GetMetricNames returns a slice with all the available metric names
 func (metric Metrics) GetMetricNames() []string {
+	metric.expireLazily()
 	metric.mutex.Lock()
 	defer metric.mutex.Unlock()
 
@@ -173,10 +722,15 @@ func (metric Metrics) DeleteMetric(metricName string) {
 	metric.mutex.Lock()
 	defer metric.mutex.Unlock()
 
-	metric.metricData.DeleteMetric(metricName)
+	metric.change(metricName, func() error {
+		metric.metricData.DeleteMetric(metricName)
+		return nil
+	})
 }
 
-// GetAllMetrics returns the underlying mapping of metric name to metric value.
+// GetAllMetrics returns a copy of the mapping of metric name to metric value,
+// calling the GaugeFunc, CounterFunc and HistogramFunc callbacks.
 func (metric Metrics) GetAllMetrics() map[string]interface{} {
+	metric.expireLazily()
 	return metric.metricData.GetAllMetrics()
 }
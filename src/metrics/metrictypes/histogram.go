@@ -0,0 +1,133 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package metric_types
+
+import (
+	"sort"
+)
+
+// Histogram Distribution of observed values
+type Histogram struct {
+	// Buckets Upper bounds of the buckets, in increasing order
+	Buckets []float64
+	// Counts Number of values in each bucket (not cumulative). It has one
+	// more element than Buckets for the values above the last bound
+	Counts []int
+	// Count Total number of values
+	Count int
+	// Sum Sum of all the values
+	Sum float64
+}
+
+// HistogramFunc callback returning the current distribution of a histogram,
+// evaluated every time the metric is read. It is read as a Histogram.
+type HistogramFunc func() Histogram
+
+// NewHistogram returns an empty Histogram
+//
+// buckets Upper bounds of the buckets, in increasing order
+func NewHistogram(buckets []float64) Histogram {
+	return Histogram{
+		Buckets: append([]float64{}, buckets...),
+		Counts:  make([]int, len(buckets)+1),
+	}
+}
+
+// Copy returns a copy of the Histogram that does not share its counts
+func (h Histogram) Copy() Histogram {
+	h.Buckets = append([]float64{}, h.Buckets...)
+	h.Counts = append([]int{}, h.Counts...)
+	return h
+}
+
+// DefaultLatencyBuckets Upper bounds (in milliseconds) of the buckets used
+// for latencies when none are given: 0.05ms to about 1 minute, growing 25%
+// per bucket
+var DefaultLatencyBuckets = getExponentialBuckets(0.05, 1.25, 64)
+
+// getExponentialBuckets returns bucket bounds growing by a factor
+func getExponentialBuckets(start float64, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// Observe adds a value to the Histogram
+//
+// value Value to add
+// count Number of times the value was observed
+func (h *Histogram) Observe(value float64, count int) {
+	// Values equal to a bound belong to its bucket
+	bucket := sort.SearchFloat64s(h.Buckets, value)
+	h.Counts[bucket] += count
+	h.Count += count
+	h.Sum += value * float64(count)
+}
+
+// Merge adds the values of another Histogram with the same buckets
+//
+// other Histogram to add, ignored if its buckets differ
+func (h *Histogram) Merge(other Histogram) {
+	if len(other.Counts) != len(h.Counts) {
+		return
+	}
+
+	for i, count := range other.Counts {
+		h.Counts[i] += count
+	}
+	h.Count += other.Count
+	h.Sum += other.Sum
+}
+
+// Reset removes all the values of the Histogram, keeping its buckets
+func (h *Histogram) Reset() {
+	for i := range h.Counts {
+		h.Counts[i] = 0
+	}
+	h.Count = 0
+	h.Sum = 0
+}
+
+// Percentile estimates a percentile of the values
+//
+// The value is interpolated linearly within the bucket holding it, values
+// above the last bound are reported as the last bound
+//
+// percentile Percentile between 0 and 1 (ex. 0.99)
+// returns 0 if the Histogram is empty
+func (h *Histogram) Percentile(percentile float64) float64 {
+	if h.Count == 0 || len(h.Buckets) == 0 {
+		return 0
+	}
+
+	rank := percentile * float64(h.Count)
+	seen := 0
+	for i, count := range h.Counts {
+		if count == 0 || float64(seen+count) < rank {
+			seen += count
+			continue
+		}
+		if i == len(h.Buckets) {
+			break
+		}
+
+		lower := 0.0
+		if i > 0 {
+			lower = h.Buckets[i-1]
+		}
+		upper := h.Buckets[i]
+		return lower + (upper-lower)*(rank-float64(seen))/float64(count)
+	}
+	return h.Buckets[len(h.Buckets)-1]
+}
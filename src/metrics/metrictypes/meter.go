@@ -0,0 +1,171 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package metric_types
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"metrics/clock"
+)
+
+// Interval at which the moving averages are updated
+const meterTickInterval = 5 * time.Second
+
+// Windows of the moving averages
+const (
+	meterWindow1  = 1 * time.Minute
+	meterWindow5  = 5 * time.Minute
+	meterWindow15 = 15 * time.Minute
+)
+
+// MeterSnapshot Rates of a Meter at some point in time, in events per second
+type MeterSnapshot struct {
+	// Count Total number of events
+	Count int
+	// Rate1 Exponentially weighted moving average over 1 minute
+	Rate1 float64
+	// Rate5 Exponentially weighted moving average over 5 minutes
+	Rate5 float64
+	// Rate15 Exponentially weighted moving average over 15 minutes
+	Rate15 float64
+	// RateMean Mean rate since the Meter was created or reset
+	RateMean float64
+}
+
+// ewma Exponentially weighted moving average of a rate
+type ewma struct {
+	alpha       float64
+	rate        float64
+	initialized bool
+}
+
+// newEWMA returns a moving average over a window, updated every tick
+func newEWMA(window time.Duration) ewma {
+	return ewma{
+		alpha: 1 - math.Exp(-float64(meterTickInterval)/float64(window)),
+	}
+}
+
+// tick updates the average with the events of one or more ticks
+//
+// events Events counted since the last update, attributed to the first tick
+// ticks Number of elapsed ticks
+func (e *ewma) tick(events int64, ticks int64) {
+	instantRate := float64(events) / meterTickInterval.Seconds()
+	if !e.initialized {
+		e.rate = instantRate
+		e.initialized = true
+	} else {
+		e.rate += e.alpha * (instantRate - e.rate)
+	}
+
+	// The remaining ticks had no events, decay the average at once
+	if ticks > 1 {
+		e.rate *= math.Pow(1-e.alpha, float64(ticks-1))
+	}
+}
+
+// Meter counts events and tracks their rate as 1, 5 and 15 minute
+// exponentially weighted moving averages and a mean rate
+//
+// Mark only updates atomic counters, the moving averages are updated lazily
+// (every 5 seconds of the clock) when the Meter is read, so there is neither
+// a ticking goroutine nor lock contention on the hot path
+type Meter struct {
+	// Updated by Mark
+	count     int64
+	uncounted int64
+
+	// Guards the moving averages
+	mutex    sync.Mutex
+	clock    clock.Clock
+	start    time.Time
+	lastTick time.Time
+	rate1    ewma
+	rate5    ewma
+	rate15   ewma
+}
+
+// NewMeter returns a new Meter
+//
+// clock Clock used to compute the rates, clock.Real if nil
+func NewMeter(meterClock clock.Clock) *Meter {
+	if meterClock == nil {
+		meterClock = clock.Real
+	}
+
+	meter := &Meter{
+		clock: meterClock,
+	}
+	meter.Reset()
+	return meter
+}
+
+// Mark records events
+//
+// count Number of events
+func (m *Meter) Mark(count int) {
+	atomic.AddInt64(&m.count, int64(count))
+	atomic.AddInt64(&m.uncounted, int64(count))
+}
+
+// Snapshot returns the current count and rates of the Meter
+func (m *Meter) Snapshot() MeterSnapshot {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.clock.Now()
+	m.tick(now)
+
+	count := atomic.LoadInt64(&m.count)
+	snapshot := MeterSnapshot{
+		Count:  int(count),
+		Rate1:  m.rate1.rate,
+		Rate5:  m.rate5.rate,
+		Rate15: m.rate15.rate,
+	}
+	if elapsed := now.Sub(m.start).Seconds(); elapsed > 0 {
+		snapshot.RateMean = float64(count) / elapsed
+	}
+	return snapshot
+}
+
+// Reset clears the count and rates of the Meter
+func (m *Meter) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	atomic.StoreInt64(&m.count, 0)
+	atomic.StoreInt64(&m.uncounted, 0)
+	m.start = m.clock.Now()
+	m.lastTick = m.start
+	m.rate1 = newEWMA(meterWindow1)
+	m.rate5 = newEWMA(meterWindow5)
+	m.rate15 = newEWMA(meterWindow15)
+}
+
+// tick updates the moving averages with the ticks elapsed until now, the
+// mutex must be held
+func (m *Meter) tick(now time.Time) {
+	ticks := int64(now.Sub(m.lastTick) / meterTickInterval)
+	if ticks <= 0 {
+		return
+	}
+
+	m.lastTick = m.lastTick.Add(time.Duration(ticks) * meterTickInterval)
+	events := atomic.SwapInt64(&m.uncounted, 0)
+	m.rate1.tick(events, ticks)
+	m.rate5.tick(events, ticks)
+	m.rate15.tick(events, ticks)
+}
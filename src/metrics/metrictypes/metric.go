@@ -11,8 +11,9 @@
 package metric_types
 
 import (
-	"time"
+	"strings"
 	"sync"
+	"time"
 
 	errWrap "metrics/error"
 )
@@ -23,24 +24,110 @@ const (
 	floatStr         = "Float64"
 	floatTypeStr     = "Fraction"
 	timeStr          = "Time"
+	durationStr      = "Duration"
 	stringStr        = "String"
+	gaugeStr         = "Gauge"
+	gaugeFuncStr     = "GaugeFunc"
+	counterFuncStr   = "CounterFunc"
+	histogramStr     = "Histogram"
+	histogramFuncStr = "HistogramFunc"
+	meterStr         = "Meter"
+	windowStr        = "Window"
+	timerStr         = "Timer"
+	ratioStr         = "Ratio"
+	boolStr          = "Bool"
 	invalidMetricStr = "InvalidMetric"
 	incMetricFnName  = "IncreaseMetric"
 	decMetricFnName  = "DecreaseMetric"
+	setMetricFnName  = "SetMetricValue"
 )
 
+// Gauge float64 value that can be set, increased or decreased. It is read as
+// a float64.
+type Gauge float64
+
+// GaugeFunc callback returning the current value of a gauge, evaluated every
+// time the metric is read. It is read as a float64.
+type GaugeFunc func() float64
+
+// CounterFunc callback returning the current value of a counter, evaluated
+// every time the metric is read. It is read as an int.
+type CounterFunc func() int
+
 // MetricSet contains a map of ints to use as metrics
 type MetricSet struct {
 	metrics map[string]interface{}
-	sync.RWMutex
+	// Number of series of every metric family, see FamilyName
+	families map[string]int
+	// The lock is shared by the copies of the MetricSet, as the map is
+	*sync.RWMutex
 }
 
 // NewMetricSet returns a new MetricSet instance
 func NewMetricSet() MetricSet {
 	metric := make(map[string]interface{})
 	return MetricSet{
-		metrics: metric,
+		metrics:  metric,
+		families: make(map[string]int),
+		RWMutex:  &sync.RWMutex{},
+	}
+}
+
+// FamilyName returns the name of the metric of a series, without its labels
+// (ex. requests for `requests{method="GET"}`)
+//
+// seriesName Name of the series
+func FamilyName(seriesName string) string {
+	if i := strings.IndexByte(seriesName, '{'); i >= 0 {
+		return seriesName[:i]
+	}
+	return seriesName
+}
+
+// evaluate returns the value of a metric as it is read, calling the callback
+// of GaugeFunc, CounterFunc and HistogramFunc metrics and taking a snapshot
+// of Meter, Window, Timer and Ratio metrics
+//
+// It must be called without holding the lock, so callbacks can read other
+// metrics
+func evaluate(value interface{}) interface{} {
+	switch metricValue := value.(type) {
+	case Gauge:
+		return float64(metricValue)
+
+	case GaugeFunc:
+		return metricValue()
+
+	case CounterFunc:
+		return metricValue()
+
+	case HistogramFunc:
+		return metricValue()
+
+	case *Meter:
+		return metricValue.Snapshot()
+
+	case *Window:
+		return metricValue.Snapshot()
+
+	case *Timer:
+		return metricValue.Snapshot()
+
+	case *Ratio:
+		return metricValue.Snapshot()
+
+	default:
+		return value
+	}
+}
+
+// copyValue returns a copy of the values that are updated in place
+// (*Histogram), the lock must be held
+func copyValue(value interface{}) interface{} {
+	if histogram, ok := value.(*Histogram); ok {
+		return histogram.Copy()
 	}
+	return value
 }
 
 // AddMetric adds a new metric to the MetricSet map
@@ -50,7 +137,39 @@ func NewMetricSet() MetricSet {
 func (c MetricSet) AddMetric(metricName string, value interface{}) {
 	c.Lock()
 	defer c.Unlock()
+	if _, ok := c.metrics[metricName]; !ok {
+		c.families[FamilyName(metricName)]++
+	}
+	c.metrics[metricName] = value
+}
+
+// AddMissingMetric adds a new metric to the MetricSet map unless it already
+// exists
+//
+// metricName Name of the metric to be added
+// value Value to initialize the added metric
+// returns whether the metric was added
+func (c MetricSet) AddMissingMetric(metricName string, value interface{}) bool {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.metrics[metricName]; ok {
+		return false
+	}
 	c.metrics[metricName] = value
+	c.families[FamilyName(metricName)]++
+	return true
+}
+
+// CountSeries returns the number of series of the MetricSet and of the
+// family of a series
+//
+// metricName Name of the series
+// returns whether the series exists
+func (c MetricSet) CountSeries(metricName string) (total int, family int, exists bool) {
+	c.RLock()
+	defer c.RUnlock()
+	_, exists = c.metrics[metricName]
+	return len(c.metrics), c.families[FamilyName(metricName)], exists
 }
 
 // DeleteMetric removes a metric from the MetricSet map
@@ -60,19 +179,27 @@ func (c MetricSet) AddMetric(metricName string, value interface{}) {
 func (c MetricSet) DeleteMetric(metricName string) {
 	c.Lock()
 	defer c.Unlock()
+	if _, ok := c.metrics[metricName]; !ok {
+		return
+	}
 	delete(c.metrics, metricName)
+	family := FamilyName(metricName)
+	if c.families[family]--; c.families[family] <= 0 {
+		delete(c.families, family)
+	}
 }
 
 // IncreaseMetric increases the value of a metric by the increment specified
 //
 // metricName Name of the metric to increase value
-// increment Quantity to add to metric value
+// increment Quantity to add to metric value (a time.Duration for Time
+// metrics)
 // returns error if specified metric does not exist
 func (c MetricSet) IncreaseMetric(metricName string, increment interface{}) error {
 	c.Lock()
 	defer c.Unlock()
 	if _, ok := c.metrics[metricName]; !ok {
-		return errWrap.MetricNotFound{metricName}
+		return errWrap.MetricNotFound{MetricName: metricName}
 	}
 
 	// Check the both values are the same type
@@ -80,7 +207,7 @@ func (c MetricSet) IncreaseMetric(metricName string, increment interface{}) erro
 	case int:
 		incValue, ok := increment.(int)
 		if !ok {
-			return errWrap.MetricInvalidType{metricName, integerStr}
+			return errWrap.MetricInvalidType{MetricName: metricName, MetricType: integerStr}
 		}
 		metricValue := c.metrics[metricName].(int)
 		c.metrics[metricName] = metricValue + incValue
@@ -88,24 +215,91 @@ func (c MetricSet) IncreaseMetric(metricName string, increment interface{}) erro
 	case float64:
 		incValue, ok := increment.(float64)
 		if !ok {
-			return errWrap.MetricInvalidType{metricName, floatStr}
+			return errWrap.MetricInvalidType{MetricName: metricName, MetricType: floatStr}
 		}
 		metricValue := c.metrics[metricName].(float64)
 		c.metrics[metricName] = metricValue + incValue
 
+	case Gauge:
+		incValue, ok := increment.(float64)
+		if !ok {
+			return errWrap.MetricInvalidType{MetricName: metricName, MetricType: floatStr}
+		}
+		metricValue := c.metrics[metricName].(Gauge)
+		c.metrics[metricName] = metricValue + Gauge(incValue)
+
+	case *Meter:
+		incValue, ok := increment.(int)
+		if !ok {
+			return errWrap.MetricInvalidType{MetricName: metricName, MetricType: integerStr}
+		}
+		c.metrics[metricName].(*Meter).Mark(incValue)
+
+	case *Window:
+		switch incValue := increment.(type) {
+		case int:
+			c.metrics[metricName].(*Window).Observe(float64(incValue))
+		case float64:
+			c.metrics[metricName].(*Window).Observe(incValue)
+		default:
+			return errWrap.MetricInvalidType{MetricName: metricName, MetricType: floatStr}
+		}
+
+	case *Timer:
+		incValue, ok := increment.(time.Duration)
+		if !ok {
+			return errWrap.MetricInvalidType{MetricName: metricName, MetricType: durationStr}
+		}
+		c.metrics[metricName].(*Timer).Observe(incValue)
+
+	case *Ratio:
+		hit, ok := increment.(bool)
+		if !ok {
+			return errWrap.MetricInvalidType{MetricName: metricName, MetricType: boolStr}
+		}
+		if hit {
+			c.metrics[metricName].(*Ratio).IncHit()
+		} else {
+			c.metrics[metricName].(*Ratio).IncMiss()
+		}
+
+	case *Histogram:
+		switch incValue := increment.(type) {
+		case int:
+			c.metrics[metricName].(*Histogram).Observe(float64(incValue), 1)
+		case float64:
+			c.metrics[metricName].(*Histogram).Observe(incValue, 1)
+		default:
+			return errWrap.MetricInvalidType{MetricName: metricName, MetricType: floatStr}
+		}
+
+	case GaugeFunc:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: gaugeFuncStr, MetricOperation: incMetricFnName}
+
+	case CounterFunc:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: counterFuncStr, MetricOperation: incMetricFnName}
+
+	case HistogramFunc:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: histogramFuncStr, MetricOperation: incMetricFnName}
+
 	case string:
 		incValue, ok := increment.(string)
 		if !ok {
-			return errWrap.MetricInvalidType{metricName, stringStr}
+			return errWrap.MetricInvalidType{MetricName: metricName, MetricType: stringStr}
 		}
 		metricValue := c.metrics[metricName].(string)
 		c.metrics[metricName] = metricValue + incValue
 
 	case time.Time:
-		return errWrap.MetricInvalidOperation{metricName, timeStr, incMetricFnName}
+		incValue, ok := increment.(time.Duration)
+		if !ok {
+			return errWrap.MetricInvalidType{MetricName: metricName, MetricType: durationStr}
+		}
+		metricValue := c.metrics[metricName].(time.Time)
+		c.metrics[metricName] = metricValue.Add(incValue)
 
 	default:
-		return errWrap.MetricNotFound{metricName}
+		return errWrap.MetricNotFound{MetricName: metricName}
 	}
 
 	return nil
@@ -114,13 +308,14 @@ func (c MetricSet) IncreaseMetric(metricName string, increment interface{}) erro
 // DecreaseMetric decreases the value of a metric by the decrement specified
 //
 // metricName Name of the metric to increase value
-// decrement Quantity to subtract to metric value
+// decrement Quantity to subtract to metric value (a time.Duration for Time
+// metrics)
 // returns error if specified metric does not exist
 func (c MetricSet) DecreaseMetric(metricName string, decrement interface{}) error {
 	c.Lock()
 	defer c.Unlock()
 	if _, ok := c.metrics[metricName]; !ok {
-		return errWrap.MetricNotFound{metricName}
+		return errWrap.MetricNotFound{MetricName: metricName}
 	}
 
 	// Check the both values are the same type
@@ -128,7 +323,7 @@ func (c MetricSet) DecreaseMetric(metricName string, decrement interface{}) erro
 	case int:
 		decValue, ok := decrement.(int)
 		if !ok {
-			return errWrap.MetricInvalidType{metricName, integerStr}
+			return errWrap.MetricInvalidType{MetricName: metricName, MetricType: integerStr}
 		}
 		metricValue := c.metrics[metricName].(int)
 		c.metrics[metricName] = metricValue - decValue
@@ -136,62 +331,248 @@ func (c MetricSet) DecreaseMetric(metricName string, decrement interface{}) erro
 	case float64:
 		decValue, ok := decrement.(float64)
 		if !ok {
-			return errWrap.MetricInvalidType{metricName, floatStr}
+			return errWrap.MetricInvalidType{MetricName: metricName, MetricType: floatStr}
 		}
 		metricValue := c.metrics[metricName].(float64)
 		c.metrics[metricName] = metricValue - decValue
 
+	case Gauge:
+		decValue, ok := decrement.(float64)
+		if !ok {
+			return errWrap.MetricInvalidType{MetricName: metricName, MetricType: floatStr}
+		}
+		metricValue := c.metrics[metricName].(Gauge)
+		c.metrics[metricName] = metricValue - Gauge(decValue)
+
+	case GaugeFunc:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: gaugeFuncStr, MetricOperation: decMetricFnName}
+
+	case CounterFunc:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: counterFuncStr, MetricOperation: decMetricFnName}
+
+	case HistogramFunc:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: histogramFuncStr, MetricOperation: decMetricFnName}
+
+	case *Meter:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: meterStr, MetricOperation: decMetricFnName}
+
+	case *Window:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: windowStr, MetricOperation: decMetricFnName}
+
+	case *Timer:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: timerStr, MetricOperation: decMetricFnName}
+
+	case *Ratio:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: ratioStr, MetricOperation: decMetricFnName}
+
+	case *Histogram:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: histogramStr, MetricOperation: decMetricFnName}
+
 	case string:
-		return errWrap.MetricInvalidOperation{metricName, stringStr, decMetricFnName}
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: stringStr, MetricOperation: decMetricFnName}
 
 	case time.Time:
-		return errWrap.MetricInvalidOperation{metricName, timeStr, decMetricFnName}
+		decValue, ok := decrement.(time.Duration)
+		if !ok {
+			return errWrap.MetricInvalidType{MetricName: metricName, MetricType: durationStr}
+		}
+		metricValue := c.metrics[metricName].(time.Time)
+		c.metrics[metricName] = metricValue.Add(-decValue)
 
 	default:
-		return errWrap.MetricNotFound{metricName}
+		return errWrap.MetricNotFound{MetricName: metricName}
+	}
+
+	return nil
+}
+
+// MarkMeter records events on a Meter metric
+//
+// Only the read lock is taken to look the Meter up, Meter metrics are
+// updated atomically.
+//
+// metricName Name of the Meter metric
+// count Number of events
+// returns error if specified metric does not exist or is not a Meter
+func (c MetricSet) MarkMeter(metricName string, count int) error {
+	meter, err := c.GetMeter(metricName)
+	if err != nil {
+		return err
+	}
+	meter.Mark(count)
+	return nil
+}
+
+// GetMeter returns a Meter metric, to mark events without looking it up
+// every time
+//
+// metricName Name of the Meter metric
+// returns error if specified metric does not exist or is not a Meter
+func (c MetricSet) GetMeter(metricName string) (*Meter, error) {
+	c.RLock()
+	value, ok := c.metrics[metricName]
+	c.RUnlock()
+	if !ok {
+		return nil, errWrap.MetricNotFound{MetricName: metricName}
+	}
+
+	meter, ok := value.(*Meter)
+	if !ok {
+		return nil, errWrap.MetricInvalidType{MetricName: metricName, MetricType: meterStr}
+	}
+	return meter, nil
+}
+
+// GetTimer returns a Timer metric, to record durations without looking it
+// up every time
+//
+// metricName Name of the Timer metric
+// returns error if specified metric does not exist or is not a Timer
+func (c MetricSet) GetTimer(metricName string) (*Timer, error) {
+	c.RLock()
+	value, ok := c.metrics[metricName]
+	c.RUnlock()
+	if !ok {
+		return nil, errWrap.MetricNotFound{MetricName: metricName}
 	}
 
+	timer, ok := value.(*Timer)
+	if !ok {
+		return nil, errWrap.MetricInvalidType{MetricName: metricName, MetricType: timerStr}
+	}
+	return timer, nil
+}
+
+// GetRatio returns a Ratio metric, to count hits and misses without looking
+// it up every time
+//
+// metricName Name of the Ratio metric
+// returns error if specified metric does not exist or is not a Ratio
+func (c MetricSet) GetRatio(metricName string) (*Ratio, error) {
+	c.RLock()
+	value, ok := c.metrics[metricName]
+	c.RUnlock()
+	if !ok {
+		return nil, errWrap.MetricNotFound{MetricName: metricName}
+	}
+
+	ratio, ok := value.(*Ratio)
+	if !ok {
+		return nil, errWrap.MetricInvalidType{MetricName: metricName, MetricType: ratioStr}
+	}
+	return ratio, nil
+}
+
+// ObserveMetric adds a value to a Window or Histogram metric
+//
+// Only the read lock is taken for Window metrics, they have their own lock.
+//
+// metricName Name of the Window or Histogram metric
+// value Value observed (ex. a latency)
+// returns error if specified metric does not exist or is not a Window or a
+// Histogram
+func (c MetricSet) ObserveMetric(metricName string, value float64) error {
+	c.RLock()
+	metricValue, ok := c.metrics[metricName]
+	c.RUnlock()
+	if !ok {
+		return errWrap.MetricNotFound{MetricName: metricName}
+	}
+
+	switch observed := metricValue.(type) {
+	case *Window:
+		observed.Observe(value)
+
+	case *Histogram:
+		c.Lock()
+		observed.Observe(value, 1)
+		c.Unlock()
+
+	default:
+		return errWrap.MetricInvalidType{MetricName: metricName, MetricType: windowStr}
+	}
 	return nil
 }
 
 // ResetMetric sets the value of a metric to nil
 //
+// Exceptions: Gauge metrics are set to 0, Meter, Window, Histogram, Timer
+// and Ratio metrics are cleared, and GaugeFunc, CounterFunc and
+// HistogramFunc metrics keep their callback.
+//
 // metricName Name of the metric to increase value
 // returns error if specified metric does not exist
 func (c MetricSet) ResetMetric(metricName string) error {
 	c.Lock()
 	defer c.Unlock()
 	if _, ok := c.metrics[metricName]; !ok {
-		return errWrap.MetricNotFound{metricName}
+		return errWrap.MetricNotFound{MetricName: metricName}
 	}
-	c.metrics[metricName] = nil
+	c.resetMetric(metricName)
 	return nil
 }
 
-// ResetAllMetrics sets the value of all metrics to nil
+// ResetAllMetrics sets the value of all metrics to nil, with the same
+// exceptions as ResetMetric
 func (c MetricSet) ResetAllMetrics() {
 	c.Lock()
 	defer c.Unlock()
 	for metricName := range c.metrics {
+		c.resetMetric(metricName)
+	}
+}
+
+// resetMetric resets a metric, the lock must be held
+func (c MetricSet) resetMetric(metricName string) {
+	switch c.metrics[metricName].(type) {
+	case Gauge:
+		c.metrics[metricName] = Gauge(0)
+
+	case GaugeFunc, CounterFunc, HistogramFunc:
+
+	case *Meter:
+		c.metrics[metricName].(*Meter).Reset()
+
+	case *Window:
+		c.metrics[metricName].(*Window).Reset()
+
+	case *Timer:
+		c.metrics[metricName].(*Timer).Reset()
+
+	case *Ratio:
+		c.metrics[metricName].(*Ratio).Reset()
+
+	case *Histogram:
+		c.metrics[metricName].(*Histogram).Reset()
+
+	default:
 		c.metrics[metricName] = nil
 	}
 }
 
 // GetMetricValue returns the value of a metric
 //
+// The callback of GaugeFunc, CounterFunc and HistogramFunc metrics is called
+// to get it.
+//
 // metricName Name of the metric to get value
 // returns error if specified metric does not exist
 func (c MetricSet) GetMetricValue(metricName string) (interface{}, error) {
 	c.RLock()
-	defer c.RUnlock()
-	if _, ok := c.metrics[metricName]; !ok {
-		return nil, errWrap.MetricNotFound{metricName}
+	value, ok := c.metrics[metricName]
+	value = copyValue(value)
+	c.RUnlock()
+	if !ok {
+		return nil, errWrap.MetricNotFound{MetricName: metricName}
 	}
-	return c.metrics[metricName], nil
+	return evaluate(value), nil
 }
 
 // SetMetricValue sets the value of a metric
 //
+// Gauge metrics only accept float64 values and GaugeFunc, CounterFunc and
+// HistogramFunc metrics cannot be set
+//
 // metricName Name of the metric to get value
 // value Value to set the metric
 // returns error if specified metric does not exist
@@ -199,18 +580,116 @@ func (c MetricSet) SetMetricValue(metricName string, value interface{}) error {
 	c.Lock()
 	defer c.Unlock()
 	if _, ok := c.metrics[metricName]; !ok {
-		return errWrap.MetricNotFound{metricName}
+		return errWrap.MetricNotFound{MetricName: metricName}
+	}
+
+	switch c.metrics[metricName].(type) {
+	case Gauge:
+		setValue, ok := value.(float64)
+		if !ok {
+			return errWrap.MetricInvalidType{MetricName: metricName, MetricType: floatStr}
+		}
+		c.metrics[metricName] = Gauge(setValue)
+
+	case GaugeFunc:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: gaugeFuncStr, MetricOperation: setMetricFnName}
+
+	case CounterFunc:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: counterFuncStr, MetricOperation: setMetricFnName}
+
+	case HistogramFunc:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: histogramFuncStr, MetricOperation: setMetricFnName}
+
+	case *Meter:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: meterStr, MetricOperation: setMetricFnName}
+
+	case *Window:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: windowStr, MetricOperation: setMetricFnName}
+
+	case *Timer:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: timerStr, MetricOperation: setMetricFnName}
+
+	case *Ratio:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: ratioStr, MetricOperation: setMetricFnName}
+
+	case *Histogram:
+		return errWrap.MetricInvalidOperation{MetricName: metricName, MetricType: histogramStr, MetricOperation: setMetricFnName}
+
+	default:
+		c.metrics[metricName] = value
 	}
-	c.metrics[metricName] = value
 	return nil
 }
 
-// GetAllMetrics returns all metrics in a map with the
-// metric name as key
+// GetAllMetrics returns a copy of all metrics in a map with the
+// metric name as key, with the values as read by GetMetricValue
 func (c MetricSet) GetAllMetrics() map[string]interface{} {
+	c.RLock()
+	metrics := make(map[string]interface{}, len(c.metrics))
+	for metricName, value := range c.metrics {
+		metrics[metricName] = copyValue(value)
+	}
+	c.RUnlock()
+
+	for metricName, value := range metrics {
+		metrics[metricName] = evaluate(value)
+	}
+	return metrics
+}
+
+// GetSnapshot returns a copy of all metrics with the values as read by
+// GetMetricValue, along with their types (as returned by GetMetricType)
+//
+// Both are copied under a single lock so they are consistent
+func (c MetricSet) GetSnapshot() (values map[string]interface{}, types map[string]string) {
+	c.RLock()
+	values = make(map[string]interface{}, len(c.metrics))
+	types = make(map[string]string, len(c.metrics))
+	for metricName, value := range c.metrics {
+		values[metricName] = copyValue(value)
+		types[metricName] = getMetricType(value)
+	}
+	c.RUnlock()
+
+	for metricName, value := range values {
+		values[metricName] = evaluate(value)
+	}
+	return values, types
+}
+
+// IsComputedMetric returns whether a metric is computed on read (GaugeFunc,
+// CounterFunc, HistogramFunc, Meter, Window, Timer or Ratio) rather than
+// holding a value
+//
+// metricName Name of the metric
+func (c MetricSet) IsComputedMetric(metricName string) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	switch c.metrics[metricName].(type) {
+	case GaugeFunc, CounterFunc, HistogramFunc, *Meter, *Window, *Timer, *Ratio:
+		return true
+
+	default:
+		return false
+	}
+}
+
+// IsCallbackMetric returns whether a metric is read from a callback
+// (GaugeFunc, CounterFunc or HistogramFunc)
+//
+// metricName Name of the metric
+func (c MetricSet) IsCallbackMetric(metricName string) bool {
 	c.RLock()
 	defer c.RUnlock()
-	return c.metrics
+
+	switch c.metrics[metricName].(type) {
+	case GaugeFunc, CounterFunc, HistogramFunc:
+		return true
+
+	default:
+		return false
+	}
 }
 
 // GetMetricsNames returns a slice with the name of all metrics
@@ -235,14 +714,39 @@ func (c MetricSet) GetMetricType(metricName string) string {
 		return invalidMetricStr
 	}
 
-	// Check the both values are the same type
-	switch c.metrics[metricName].(type) {
+	return getMetricType(c.metrics[metricName])
+}
+
+// getMetricType returns the type of a metric value
+func getMetricType(value interface{}) string {
+	switch value.(type) {
 	case int:
 		return intTypeStr
 
 	case float64:
 		return floatTypeStr
 
+	case Gauge, GaugeFunc:
+		return gaugeStr
+
+	case CounterFunc:
+		return intTypeStr
+
+	case Histogram, *Histogram, HistogramFunc:
+		return histogramStr
+
+	case *Meter:
+		return meterStr
+
+	case *Window:
+		return windowStr
+
+	case *Timer:
+		return timerStr
+
+	case *Ratio:
+		return ratioStr
+
 	case string:
 		return stringStr
 
@@ -0,0 +1,138 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package metric_types
+
+import (
+	"sync"
+	"time"
+
+	"metrics/clock"
+)
+
+// RatioSnapshot Numerator and denominator of a Ratio
+type RatioSnapshot struct {
+	// Numerator Number of hits (ex. successful requests)
+	Numerator int
+	// Denominator Number of hits and misses (ex. all the requests)
+	Denominator int
+	// Ratio Numerator / Denominator, 0 without any denominator
+	Ratio float64
+	// Windows Ratio during the last spans, shortest first
+	Windows []RatioWindow
+}
+
+// RatioWindow Numerator and denominator of a Ratio during the last Span
+type RatioWindow struct {
+	Span        time.Duration
+	Numerator   int
+	Denominator int
+	Ratio       float64
+}
+
+// Ratio tracks a numerator and a denominator (ex. cache hits out of
+// lookups) updated together, so the ratio can be read and aggregated across
+// instances from both counts
+type Ratio struct {
+	mutex       sync.Mutex
+	numerator   int
+	denominator int
+	// Sliding windows of the numerator and denominator, nil without spans
+	numerators   *Window
+	denominators *Window
+}
+
+// NewRatio returns a new Ratio
+//
+// spans Lengths of the sliding windows of the ratio, none if nil
+// clock Clock used to rotate the windows, clock.Real if nil
+func NewRatio(spans []time.Duration, ratioClock clock.Clock) *Ratio {
+	r := &Ratio{}
+	if len(spans) > 0 {
+		r.numerators = NewWindow(spans, nil, ratioClock)
+		r.denominators = NewWindow(spans, nil, ratioClock)
+	}
+	return r
+}
+
+// Add adds to the numerator and the denominator
+//
+// numerator Increment of the numerator (ex. successful requests)
+// denominator Increment of the denominator (ex. all the requests)
+func (r *Ratio) Add(numerator int, denominator int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.numerator += numerator
+	r.denominator += denominator
+	if r.numerators != nil {
+		r.numerators.Observe(float64(numerator))
+		r.denominators.Observe(float64(denominator))
+	}
+}
+
+// IncHit adds 1 to the numerator and the denominator
+func (r *Ratio) IncHit() {
+	r.Add(1, 1)
+}
+
+// IncMiss adds 1 to the denominator only
+func (r *Ratio) IncMiss() {
+	r.Add(0, 1)
+}
+
+// Snapshot returns the numerator, denominator and ratio, overall and per
+// window
+func (r *Ratio) Snapshot() RatioSnapshot {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	snapshot := RatioSnapshot{
+		Numerator:   r.numerator,
+		Denominator: r.denominator,
+		Ratio:       ratio(r.numerator, r.denominator),
+	}
+	if r.numerators != nil {
+		numerators := r.numerators.Snapshot()
+		denominators := r.denominators.Snapshot()
+		snapshot.Windows = make([]RatioWindow, len(numerators))
+		for i := range numerators {
+			numerator, denominator := int(numerators[i].Sum), int(denominators[i].Sum)
+			snapshot.Windows[i] = RatioWindow{
+				Span:        numerators[i].Span,
+				Numerator:   numerator,
+				Denominator: denominator,
+				Ratio:       ratio(numerator, denominator),
+			}
+		}
+	}
+	return snapshot
+}
+
+// Reset sets the numerator and denominator to 0 and clears the windows
+func (r *Ratio) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.numerator = 0
+	r.denominator = 0
+	if r.numerators != nil {
+		r.numerators.Reset()
+		r.denominators.Reset()
+	}
+}
+
+// ratio Numerator / denominator, 0 without any denominator
+func ratio(numerator int, denominator int) float64 {
+	if denominator == 0 {
+		return 0
+	}
+	return float64(numerator) / float64(denominator)
+}
@@ -0,0 +1,147 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package metric_types
+
+import (
+	"sync"
+	"time"
+
+	"metrics/clock"
+)
+
+// TimerSnapshot Durations observed by a Timer
+type TimerSnapshot struct {
+	// Count Number of durations
+	Count int
+	Sum   time.Duration
+	Min   time.Duration
+	Max   time.Duration
+	Mean  time.Duration
+	// Percentiles of the durations, interpolated from the Histogram
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	// Histogram Distribution of the durations, in milliseconds
+	Histogram Histogram
+}
+
+// Timer records durations (ex. of database queries) with their count, sum,
+// min, max and distribution
+//
+//	defer timer.Start()()
+type Timer struct {
+	mutex     sync.Mutex
+	clock     clock.Clock
+	count     int
+	sum       time.Duration
+	min       time.Duration
+	max       time.Duration
+	histogram Histogram
+}
+
+// NewTimer returns a new Timer
+//
+// buckets Upper bounds (ms) of the buckets of the distribution,
+// DefaultLatencyBuckets if nil
+// clock Clock used by Start and ObserveSince, clock.Real if nil
+func NewTimer(buckets []float64, timerClock clock.Clock) *Timer {
+	if buckets == nil {
+		buckets = DefaultLatencyBuckets
+	}
+	if timerClock == nil {
+		timerClock = clock.Real
+	}
+
+	return &Timer{
+		clock:     timerClock,
+		histogram: NewHistogram(buckets),
+	}
+}
+
+// Observe records a duration
+func (t *Timer) Observe(duration time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.count == 0 || duration < t.min {
+		t.min = duration
+	}
+	if t.count == 0 || duration > t.max {
+		t.max = duration
+	}
+	t.count++
+	t.sum += duration
+	t.histogram.Observe(float64(duration)/float64(time.Millisecond), 1)
+}
+
+// ObserveSince records the duration elapsed since a start time
+//
+// start Start time, from the clock of the Timer
+func (t *Timer) ObserveSince(start time.Time) {
+	t.Observe(t.clock.Now().Sub(start))
+}
+
+// Start starts timing, the returned function records the duration elapsed
+// since
+//
+// Ex. defer timer.Start()()
+func (t *Timer) Start() func() {
+	start := t.clock.Now()
+	return func() {
+		t.ObserveSince(start)
+	}
+}
+
+// Snapshot returns the durations observed
+func (t *Timer) Snapshot() TimerSnapshot {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	snapshot := TimerSnapshot{
+		Count:     t.count,
+		Sum:       t.sum,
+		Min:       t.min,
+		Max:       t.max,
+		Histogram: t.histogram.Copy(),
+	}
+	if t.count > 0 {
+		snapshot.Mean = t.sum / time.Duration(t.count)
+		snapshot.P50 = t.percentile(0.5)
+		snapshot.P90 = t.percentile(0.9)
+		snapshot.P99 = t.percentile(0.99)
+	}
+	return snapshot
+}
+
+// percentile Percentile of the durations, clamped to the min and max, the
+// lock must be held
+func (t *Timer) percentile(percentile float64) time.Duration {
+	value := time.Duration(t.histogram.Percentile(percentile) * float64(time.Millisecond))
+	if value < t.min {
+		return t.min
+	}
+	if value > t.max {
+		return t.max
+	}
+	return value
+}
+
+// Reset clears the durations observed
+func (t *Timer) Reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.count = 0
+	t.sum = 0
+	t.min = 0
+	t.max = 0
+	t.histogram.Reset()
+}
@@ -0,0 +1,238 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package metric_types
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"metrics/clock"
+)
+
+// Default number of rotating buckets of every window
+const defaultWindowBuckets = 60
+
+// WindowSnapshot Aggregate of the values observed during the last Span
+type WindowSnapshot struct {
+	Span time.Duration
+	// Count Number of observations
+	Count int
+	// Sum Sum of the observed values (ex. the increments of a counter)
+	Sum float64
+	// Rate Sum per second over the span
+	Rate float64
+	Min  float64
+	Max  float64
+	// Percentiles of the values, only for windows with a histogram
+	P50 float64
+	P90 float64
+	P99 float64
+}
+
+// windowBucket Values observed during one slice of a window
+type windowBucket struct {
+	start     time.Time
+	count     int
+	sum       float64
+	min       float64
+	max       float64
+	histogram *Histogram
+}
+
+// reset empties the bucket for a new slice of time
+func (b *windowBucket) reset(start time.Time) {
+	b.start = start
+	b.count = 0
+	b.sum = 0
+	b.min = math.Inf(1)
+	b.max = math.Inf(-1)
+	if b.histogram != nil {
+		b.histogram.Reset()
+	}
+}
+
+// window Values observed during one span, split in rotating buckets
+type window struct {
+	span         time.Duration
+	bucketLength time.Duration
+	buckets      []windowBucket
+	// head Index of the bucket of the current slice of time
+	head int
+}
+
+// Window aggregates the values observed over sliding time windows (ex. the
+// last 1m, 5m and 1h) without resetting anything
+//
+// Every span is split into rotating buckets, so a span covers between
+// (buckets - 1) and buckets bucket lengths of the most recent values. Windows
+// created with histogram buckets also report percentiles.
+type Window struct {
+	mutex   sync.Mutex
+	clock   clock.Clock
+	windows []*window
+}
+
+// NewWindow returns a new Window
+//
+// spans Lengths of the sliding windows
+// buckets Upper bounds of the histogram buckets, nil to not keep
+// percentiles (ex. for counters)
+// clock Clock used to rotate the buckets, clock.Real if nil
+func NewWindow(spans []time.Duration, buckets []float64, windowClock clock.Clock) *Window {
+	if windowClock == nil {
+		windowClock = clock.Real
+	}
+
+	w := &Window{
+		clock: windowClock,
+	}
+	spans = append([]time.Duration{}, spans...)
+	sort.Slice(spans, func(i, j int) bool { return spans[i] < spans[j] })
+
+	now := windowClock.Now()
+	for _, span := range spans {
+		current := &window{
+			span:         span,
+			bucketLength: span / defaultWindowBuckets,
+			buckets:      make([]windowBucket, defaultWindowBuckets),
+		}
+		if current.bucketLength <= 0 {
+			current.bucketLength = 1
+		}
+		for i := range current.buckets {
+			if buckets != nil {
+				histogram := NewHistogram(buckets)
+				current.buckets[i].histogram = &histogram
+			}
+			current.buckets[i].reset(time.Time{})
+		}
+		current.buckets[0].start = now.Truncate(current.bucketLength)
+		w.windows = append(w.windows, current)
+	}
+	return w
+}
+
+// rotate moves the head of a window to the bucket of now, emptying the
+// buckets of the slices of time that were skipped
+func (w *window) rotate(now time.Time) {
+	start := now.Truncate(w.bucketLength)
+	head := &w.buckets[w.head]
+	if !start.After(head.start) {
+		return
+	}
+
+	steps := int(start.Sub(head.start) / w.bucketLength)
+	if steps > len(w.buckets) {
+		steps = len(w.buckets)
+	}
+	for i := 1; i <= steps; i++ {
+		w.head = (w.head + 1) % len(w.buckets)
+		w.buckets[w.head].reset(start.Add(time.Duration(i-steps) * w.bucketLength))
+	}
+}
+
+// Observe adds a value to every span of the Window
+//
+// value Value observed (ex. 1 for an event, or a latency)
+func (w *Window) Observe(value float64) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	now := w.clock.Now()
+	for _, current := range w.windows {
+		current.rotate(now)
+		bucket := &current.buckets[current.head]
+		bucket.count++
+		bucket.sum += value
+		bucket.min = math.Min(bucket.min, value)
+		bucket.max = math.Max(bucket.max, value)
+		if bucket.histogram != nil {
+			bucket.histogram.Observe(value, 1)
+		}
+	}
+}
+
+// Snapshot returns the aggregate of every span, shortest first
+func (w *Window) Snapshot() []WindowSnapshot {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	now := w.clock.Now()
+	snapshots := make([]WindowSnapshot, 0, len(w.windows))
+	for _, current := range w.windows {
+		current.rotate(now)
+		snapshots = append(snapshots, current.snapshot(now))
+	}
+	return snapshots
+}
+
+// snapshot aggregates the buckets of a window still within its span
+func (w *window) snapshot(now time.Time) WindowSnapshot {
+	snapshot := WindowSnapshot{
+		Span: w.span,
+		Min:  math.Inf(1),
+		Max:  math.Inf(-1),
+	}
+	var histogram *Histogram
+	oldest := now.Add(-w.span)
+	for i := range w.buckets {
+		bucket := &w.buckets[i]
+		if bucket.count == 0 || !bucket.start.Add(w.bucketLength).After(oldest) {
+			continue
+		}
+
+		snapshot.Count += bucket.count
+		snapshot.Sum += bucket.sum
+		snapshot.Min = math.Min(snapshot.Min, bucket.min)
+		snapshot.Max = math.Max(snapshot.Max, bucket.max)
+		if bucket.histogram != nil {
+			if histogram == nil {
+				merged := NewHistogram(bucket.histogram.Buckets)
+				histogram = &merged
+			}
+			histogram.Merge(*bucket.histogram)
+		}
+	}
+
+	if snapshot.Count == 0 {
+		snapshot.Min = 0
+		snapshot.Max = 0
+		return snapshot
+	}
+	snapshot.Rate = snapshot.Sum / w.span.Seconds()
+	if histogram != nil {
+		// Percentiles cannot be outside the observed values
+		clamp := func(value float64) float64 {
+			return math.Max(snapshot.Min, math.Min(snapshot.Max, value))
+		}
+		snapshot.P50 = clamp(histogram.Percentile(0.5))
+		snapshot.P90 = clamp(histogram.Percentile(0.9))
+		snapshot.P99 = clamp(histogram.Percentile(0.99))
+	}
+	return snapshot
+}
+
+// Reset removes all the values of the Window
+func (w *Window) Reset() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	now := w.clock.Now()
+	for _, current := range w.windows {
+		for i := range current.buckets {
+			current.buckets[i].reset(time.Time{})
+		}
+		current.head = 0
+		current.buckets[0].start = now.Truncate(current.bucketLength)
+	}
+}
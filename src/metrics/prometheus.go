@@ -0,0 +1,269 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// promFamily Samples of a metric family of the Prometheus text format
+type promFamily struct {
+	name    string
+	kind    string
+	help    string
+	samples []string
+}
+
+// promWriter Groups the samples of the metrics by family
+type promWriter struct {
+	families map[string]*promFamily
+}
+
+// family Get a family, adding it if it does not exist
+//
+// name Name of the family
+// kind Prometheus type of the family (counter, gauge or histogram)
+func (p *promWriter) family(name string, kind string, help string) *promFamily {
+	family, ok := p.families[name]
+	if !ok {
+		family = &promFamily{name: name, kind: kind, help: help}
+		p.families[name] = family
+	}
+	return family
+}
+
+// add Add a sample to a family
+//
+// suffix Suffix of the sample name, ex. _bucket for histograms
+// labels Labels of the series without braces, ex. `method="GET"`
+// extra Labels added to the series, ex. `le="5"`
+func (f *promFamily) add(suffix string, labels string, extra string, value float64) {
+	if labels != "" && extra != "" {
+		labels += ","
+	}
+	labels += extra
+	sample := f.name + suffix
+	if labels != "" {
+		sample += "{" + labels + "}"
+	}
+	f.samples = append(f.samples, sample+" "+formatPromValue(value))
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition
+// format
+//
+// - Counter: counter
+// - Fraction, Gauge: gauge
+// - String: gauge set to 1 with the text in a value label
+// - Time: gauge of the unix timestamp (in seconds), plus a <name>_age_seconds
+// gauge and, for the metrics with a stale age, a <name>_stale gauge (0 or 1)
+// - Histogram: histogram
+// - Meter: counter of the events plus a <name>_rate gauge per window
+// - Window: <name>_count, <name>_sum and <name>_rate gauges and the
+// quantiles, per span
+// - Timer: histogram of the durations in seconds, plus <name>_min_seconds and
+// <name>_max_seconds gauges
+// - Ratio: <name>_numerator and <name>_denominator counters, to aggregate
+// across instances, plus the ratio gauge overall and per window
+//
+// Labeled series (ex. `requests{method="GET"}`) are grouped by metric, with
+// the help text set with Describe. The text format has no units (# UNIT is
+// only part of OpenMetrics), they belong in the metric names (ex. _seconds).
+//
+// w Writer of the exposition
+func (metric Metrics) WritePrometheus(w io.Writer) error {
+	snapshot := metric.Snapshot()
+	now := snapshot.Time()
+
+	metric.mutex.Lock()
+	descriptions := make(map[string]description, len(metric.options.descriptions))
+	for metricName, desc := range metric.options.descriptions {
+		descriptions[metricName] = desc
+	}
+	staleAfter := make(map[string]time.Duration, len(metric.options.staleAfter))
+	for metricName, maxAge := range metric.options.staleAfter {
+		staleAfter[metricName] = maxAge
+	}
+	metric.mutex.Unlock()
+
+	p := &promWriter{families: map[string]*promFamily{}}
+	for _, metricName := range snapshot.GetMetricNames() {
+		value, _ := snapshot.ReadMetric(metricName)
+		baseName, labels := metricName, ""
+		if i := strings.IndexByte(metricName, '{'); i >= 0 && strings.HasSuffix(metricName, "}") {
+			baseName, labels = metricName[:i], metricName[i+1:len(metricName)-1]
+		}
+		name := promName(baseName)
+		desc := descriptions[baseName]
+
+		switch metricValue := value.(type) {
+		case int:
+			p.family(name, "counter", desc.help).add("", labels, "", float64(metricValue))
+
+		case float64:
+			p.family(name, "gauge", desc.help).add("", labels, "", metricValue)
+
+		case string:
+			p.family(name, "gauge", desc.help).add("", labels, promLabel("value", metricValue), 1)
+
+		case time.Time:
+			family := p.family(name, "gauge", desc.help)
+			if metricValue.IsZero() {
+				family.add("", labels, "", 0)
+			} else {
+				family.add("", labels, "", float64(metricValue.UnixNano())/1e9)
+				p.family(name+"_age_seconds", "gauge", "Seconds since "+baseName).
+					add("", labels, "", now.Sub(metricValue).Seconds())
+			}
+			if maxAge, ok := staleAfter[metricName]; ok {
+				stale := 0.0
+				if isStale(metricValue, maxAge, now) {
+					stale = 1
+				}
+				p.family(name+"_stale", "gauge", fmt.Sprintf("Whether %s is older than %s", baseName, maxAge)).
+					add("", labels, "", stale)
+			}
+
+		case HistogramValue:
+			family := p.family(name, "histogram", desc.help)
+			cumulative := 0
+			for i, upperBound := range metricValue.Buckets {
+				cumulative += metricValue.Counts[i]
+				family.add("_bucket", labels, promLabel("le", formatPromValue(upperBound)), float64(cumulative))
+			}
+			family.add("_bucket", labels, `le="+Inf"`, float64(metricValue.Count))
+			family.add("_sum", labels, "", metricValue.Sum)
+			family.add("_count", labels, "", float64(metricValue.Count))
+
+		case TimerSnapshot:
+			family := p.family(name, "histogram", desc.help)
+			cumulative := 0
+			for i, upperBound := range metricValue.Histogram.Buckets {
+				cumulative += metricValue.Histogram.Counts[i]
+				family.add("_bucket", labels, promLabel("le", formatPromValue(upperBound/1000)), float64(cumulative))
+			}
+			family.add("_bucket", labels, `le="+Inf"`, float64(metricValue.Count))
+			family.add("_sum", labels, "", metricValue.Sum.Seconds())
+			family.add("_count", labels, "", float64(metricValue.Count))
+			p.family(name+"_min_seconds", "gauge", "Shortest duration of "+baseName).
+				add("", labels, "", metricValue.Min.Seconds())
+			p.family(name+"_max_seconds", "gauge", "Longest duration of "+baseName).
+				add("", labels, "", metricValue.Max.Seconds())
+
+		case RatioSnapshot:
+			p.family(name+"_numerator", "counter", "Numerator of "+baseName).
+				add("", labels, "", float64(metricValue.Numerator))
+			p.family(name+"_denominator", "counter", "Denominator of "+baseName).
+				add("", labels, "", float64(metricValue.Denominator))
+			family := p.family(name, "gauge", desc.help)
+			family.add("", labels, "", metricValue.Ratio)
+			for _, window := range metricValue.Windows {
+				family.add("", labels, promLabel("window", formatSpan(window.Span)), window.Ratio)
+			}
+
+		case MeterSnapshot:
+			p.family(name, "counter", desc.help).add("", labels, "", float64(metricValue.Count))
+			rates := p.family(name+"_rate", "gauge", "Events per second of "+baseName)
+			rates.add("", labels, `window="1m"`, metricValue.Rate1)
+			rates.add("", labels, `window="5m"`, metricValue.Rate5)
+			rates.add("", labels, `window="15m"`, metricValue.Rate15)
+			rates.add("", labels, `window="mean"`, metricValue.RateMean)
+
+		case []WindowSnapshot:
+			counts := p.family(name+"_count", "gauge", "Observations of "+baseName)
+			sums := p.family(name+"_sum", "gauge", "Sum of the observations of "+baseName)
+			rates := p.family(name+"_rate", "gauge", "Sum per second of the observations of "+baseName)
+			for _, window := range metricValue {
+				span := promLabel("window", formatSpan(window.Span))
+				counts.add("", labels, span, float64(window.Count))
+				sums.add("", labels, span, window.Sum)
+				rates.add("", labels, span, window.Rate)
+				if window.P99 > 0 {
+					quantiles := p.family(name, "gauge", desc.help)
+					quantiles.add("", labels, span+`,quantile="0.5"`, window.P50)
+					quantiles.add("", labels, span+`,quantile="0.9"`, window.P90)
+					quantiles.add("", labels, span+`,quantile="0.99"`, window.P99)
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(p.families))
+	for name := range p.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buffer := bufio.NewWriter(w)
+	for _, name := range names {
+		family := p.families[name]
+		if family.help != "" {
+			fmt.Fprintf(buffer, "# HELP %s %s\n", name, helpEscaper.Replace(family.help))
+		}
+		fmt.Fprintf(buffer, "# TYPE %s %s\n", name, family.kind)
+		for _, sample := range family.samples {
+			fmt.Fprintln(buffer, sample)
+		}
+	}
+	return buffer.Flush()
+}
+
+// helpEscaper Escapes the help texts of the Prometheus text format
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// promName Replace the characters that are not valid in Prometheus metric
+// names by underscores
+func promName(name string) string {
+	valid := []byte(name)
+	for i, c := range valid {
+		if !(c == '_' || c == ':' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			valid[i] = '_'
+		}
+	}
+	return string(valid)
+}
+
+// promLabel Format a label, ex. value="text"
+func promLabel(name string, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+// formatPromValue Format a sample value
+func formatPromValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// formatSpan Format the span of a window, ex. 5m instead of 5m0s
+func formatSpan(span time.Duration) string {
+	text := span.String()
+	if strings.HasSuffix(text, "m0s") {
+		text = strings.TrimSuffix(text, "0s")
+	}
+	if strings.HasSuffix(text, "h0m") {
+		text = strings.TrimSuffix(text, "0m")
+	}
+	return text
+}
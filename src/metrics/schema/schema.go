@@ -0,0 +1,350 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+// Package schema loads metric definitions from JSON or YAML schema files
+//
+// A schema lists the metrics of an application with their type, help text,
+// unit, labels and (for Histogram metrics) buckets:
+//
+//	metrics:
+//	  - name: http_requests_total
+//	    type: Counter
+//	    help: HTTP requests served
+//	    labels: [method, code]
+//	  - name: http_request_duration
+//	    type: Histogram
+//	    unit: ms
+//	    buckets: [5, 10, 50, 100, 500]
+//
+// NewMetrics creates the Metrics of a schema and the metrics-gen command
+// generates typed accessors from it.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	gometrics "metrics"
+	errWrap "metrics/error"
+)
+
+// Metric types of the definitions
+const (
+	Counter   = "Counter"
+	Fraction  = "Fraction"
+	Gauge     = "Gauge"
+	String    = "String"
+	Time      = "Time"
+	Histogram = "Histogram"
+)
+
+var (
+	metricNameRegexp = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRegexp  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// Indexes in the fields of the encoding/json errors, ex. metrics.0.name
+	indexRegexp = regexp.MustCompile(`\.(\d+)`)
+)
+
+// Schema Definitions of the metrics of an application
+type Schema struct {
+	Metrics []Definition `json:"metrics"`
+}
+
+// Definition Definition of a metric
+//
+// Metrics with labels are a family of series, one per combination of label
+// values (ex. `http_requests_total{code="200",method="GET"}`), created when
+// first used.
+type Definition struct {
+	// Name Name of the metric, ex. http_requests_total
+	Name string `json:"name"`
+	// Type Counter, Fraction, Gauge, String, Time or Histogram
+	Type string `json:"type"`
+	// Help One line description of the metric
+	Help string `json:"help,omitempty"`
+	// Unit Unit of the values, ex. seconds
+	Unit string `json:"unit,omitempty"`
+	// Labels Names of the labels of the series of the metric
+	Labels []string `json:"labels,omitempty"`
+	// Buckets Upper bounds of the buckets of a Histogram, in increasing
+	// order, gometrics.DefaultLatencyBuckets if empty
+	Buckets []float64 `json:"buckets,omitempty"`
+}
+
+// Load reads and validates a schema file
+//
+// path Path of the schema, its extension (.json, .yaml or .yml) sets its
+// format
+// returns errWrap.SchemaInvalid errors (joined if there are several) if the
+// schema can not be parsed or is not valid
+func Load(path string) (Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Schema{}, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ParseJSON(path, data)
+	case ".yaml", ".yml":
+		return ParseYAML(path, data)
+	default:
+		return Schema{}, errWrap.SchemaInvalid{Path: path, Reason: "unknown extension, expected .json, .yaml or .yml"}
+	}
+}
+
+// ParseJSON parses and validates a JSON schema
+//
+// name Name of the schema in the errors, ex. its path
+// data Content of the schema
+func ParseJSON(name string, data []byte) (Schema, error) {
+	schema, err := decode(name, data)
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return Schema{}, errWrap.SchemaInvalid{Path: name, Field: position(data, syntaxErr.Offset), Reason: "invalid JSON", Cause: err}
+		}
+		return Schema{}, err
+	}
+	if err := schema.validate(name); err != nil {
+		return Schema{}, err
+	}
+	return schema, nil
+}
+
+// ParseYAML parses and validates a YAML schema
+//
+// Only the block mappings and sequences, flow sequences, comments and
+// scalars of YAML are supported, which is all a schema needs.
+//
+// name Name of the schema in the errors, ex. its path
+// data Content of the schema
+func ParseYAML(name string, data []byte) (Schema, error) {
+	document, err := parseYAML(name, data)
+	if err != nil {
+		return Schema{}, err
+	}
+	data, err = json.Marshal(document)
+	if err != nil {
+		return Schema{}, errWrap.SchemaInvalid{Path: name, Reason: "invalid YAML", Cause: err}
+	}
+
+	schema, err := decode(name, data)
+	if err != nil {
+		return Schema{}, err
+	}
+	if err := schema.validate(name); err != nil {
+		return Schema{}, err
+	}
+	return schema, nil
+}
+
+// decode Decode a JSON schema, rejecting unknown fields
+func decode(name string, data []byte) (Schema, error) {
+	var schema Schema
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&schema); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			reason := fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value)
+			field := indexRegexp.ReplaceAllString(typeErr.Field, "[$1]")
+			return Schema{}, errWrap.SchemaInvalid{Path: name, Field: field, Reason: reason, Cause: err}
+		}
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return Schema{}, errWrap.SchemaInvalid{Path: name, Field: strings.Trim(field, `"`), Reason: "unknown field"}
+		}
+		return Schema{}, err
+	}
+	if decoder.More() {
+		return Schema{}, errWrap.SchemaInvalid{Path: name, Field: position(data, decoder.InputOffset()), Reason: "unexpected data after the schema"}
+	}
+	return schema, nil
+}
+
+// position Line and column of an offset, ex. "line 3, column 14"
+func position(data []byte, offset int64) string {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Sprintf("line %d, column %d", line, column)
+}
+
+// Validate checks that the definitions of the schema are valid
+//
+// returns errWrap.SchemaInvalid errors, joined if there are several
+func (s Schema) Validate() error {
+	return s.validate("")
+}
+
+// validate Check the definitions of the schema
+//
+// name Name of the schema in the errors
+func (s Schema) validate(name string) error {
+	var errs []error
+	invalid := func(i int, field string, reason string, args ...interface{}) {
+		errs = append(errs, errWrap.SchemaInvalid{
+			Path:   name,
+			Field:  fmt.Sprintf("metrics[%d].%s", i, field),
+			Reason: fmt.Sprintf(reason, args...),
+		})
+	}
+
+	if len(s.Metrics) == 0 {
+		errs = append(errs, errWrap.SchemaInvalid{Path: name, Field: "metrics", Reason: "no metrics are defined"})
+	}
+
+	names := map[string]int{}
+	for i, definition := range s.Metrics {
+		if !metricNameRegexp.MatchString(definition.Name) {
+			invalid(i, "name", "%q is not a valid metric name", definition.Name)
+		} else if first, ok := names[definition.Name]; ok {
+			invalid(i, "name", "%q is already defined by metrics[%d]", definition.Name, first)
+		} else {
+			names[definition.Name] = i
+		}
+
+		switch definition.Type {
+		case Counter, Fraction, Gauge, String, Time, Histogram:
+		case "":
+			invalid(i, "type", "missing type")
+		default:
+			invalid(i, "type", "unknown type %q, expected Counter, Fraction, Gauge, String, Time or Histogram", definition.Type)
+		}
+
+		if strings.ContainsAny(definition.Help, "\n\r") {
+			invalid(i, "help", "the help text must be a single line")
+		}
+
+		labels := map[string]bool{}
+		for j, label := range definition.Labels {
+			field := fmt.Sprintf("labels[%d]", j)
+			switch {
+			case !labelNameRegexp.MatchString(label):
+				invalid(i, field, "%q is not a valid label name", label)
+			case strings.HasPrefix(label, "__"):
+				invalid(i, field, "label names starting with __ are reserved")
+			case labels[label]:
+				invalid(i, field, "label %q is repeated", label)
+			}
+			labels[label] = true
+		}
+
+		if len(definition.Buckets) > 0 && definition.Type != Histogram {
+			invalid(i, "buckets", "only Histogram metrics have buckets")
+		}
+		for j := 1; j < len(definition.Buckets); j++ {
+			if definition.Buckets[j] <= definition.Buckets[j-1] {
+				invalid(i, fmt.Sprintf("buckets[%d]", j), "buckets must be in increasing order, %v follows %v", definition.Buckets[j], definition.Buckets[j-1])
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Lookup returns the definition of a metric
+//
+// name Name of the metric
+func (s Schema) Lookup(name string) (Definition, bool) {
+	for _, definition := range s.Metrics {
+		if definition.Name == name {
+			return definition, true
+		}
+	}
+	return Definition{}, false
+}
+
+// NewMetrics validates a schema and returns its Metrics
+//
+// The metrics without labels are created with their initial value, the
+// series of the metrics with labels are created by Definition.Series. All
+// of them are described with their help text and unit.
+func NewMetrics(schema Schema) (gometrics.Metrics, error) {
+	if err := schema.Validate(); err != nil {
+		return gometrics.Metrics{}, err
+	}
+
+	initialValues := map[string]interface{}{}
+	for _, definition := range schema.Metrics {
+		if len(definition.Labels) == 0 {
+			initialValues[definition.Name] = definition.NewValue()
+		}
+	}
+
+	metrics := gometrics.NewMetrics(initialValues)
+	for _, definition := range schema.Metrics {
+		metrics.Describe(definition.Name, definition.Help, definition.Unit)
+	}
+	return metrics, nil
+}
+
+// NewValue returns the initial value of a metric (or of one of its series),
+// as given to gometrics.NewMetrics
+//
+// Histogram metrics are a *gometrics.HistogramValue, so that ObserveMetric
+// can add values to them
+func (d Definition) NewValue() interface{} {
+	switch d.Type {
+	case Counter:
+		return 0
+	case Fraction:
+		return 0.0
+	case Gauge:
+		return gometrics.GaugeValue(0)
+	case String:
+		return ""
+	case Time:
+		return time.Time{}
+	case Histogram:
+		buckets := d.Buckets
+		if len(buckets) == 0 {
+			buckets = gometrics.DefaultLatencyBuckets
+		}
+		histogram := gometrics.NewHistogram(buckets)
+		return &histogram
+	default:
+		return nil
+	}
+}
+
+// Series returns the name of the series of a metric with the given label
+// values, adding it to metrics if it does not exist yet
+//
+// Beyond the cardinality limits of metrics, the name of the overflow series
+// of the metric is returned instead (see Metrics.AddSeries).
+//
+// metrics Metrics of the schema
+// labelValues Values of the labels, in the order of Labels
+// returns error if the number of values does not match the labels
+func (d Definition) Series(metrics gometrics.Metrics, labelValues ...string) (string, error) {
+	if len(labelValues) != len(d.Labels) {
+		return "", errWrap.ValueAssertionInvalid{Value: labelValues, ExpectedType: fmt.Sprintf("%d label values of %s", len(d.Labels), d.Name)}
+	}
+	if len(d.Labels) == 0 {
+		return d.Name, nil
+	}
+
+	labels := make(map[string]string, len(d.Labels))
+	for i, label := range d.Labels {
+		labels[label] = labelValues[i]
+	}
+	return metrics.AddSeries(d.Name, labels, d.NewValue()), nil
+}
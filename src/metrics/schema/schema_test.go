@@ -0,0 +1,167 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package schema
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	errWrap "metrics/error"
+)
+
+// schemaErrors Get the fields and reasons of the SchemaInvalid errors
+// joined in an error
+func schemaErrors(t *testing.T, err error) []string {
+	t.Helper()
+
+	if err == nil {
+		return nil
+	}
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	fields := []string{}
+	for _, err := range errs {
+		var schemaErr errWrap.SchemaInvalid
+		if !errors.As(err, &schemaErr) {
+			t.Fatalf("got %v, want a SchemaInvalid error", err)
+		}
+		fields = append(fields, schemaErr.Field+": "+schemaErr.Reason)
+	}
+	return fields
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		metrics []Definition
+		// Fields and reasons of the expected errors
+		want []string
+	}{
+		{
+			name: "valid",
+			metrics: []Definition{
+				{Name: "requests_total", Type: Counter, Help: "Requests", Labels: []string{"method", "code"}},
+				{Name: "ns:latency", Type: Histogram, Unit: "ms", Buckets: []float64{1, 5, 10}},
+				{Name: "load", Type: Gauge},
+				{Name: "hit_ratio", Type: Fraction},
+				{Name: "version", Type: String},
+				{Name: "started", Type: Time},
+			},
+		},
+		{
+			name: "no metrics",
+			want: []string{"metrics: no metrics are defined"},
+		},
+		{
+			name: "invalid names",
+			metrics: []Definition{
+				{Name: "", Type: Counter},
+				{Name: "1st", Type: Counter},
+				{Name: "a-b", Type: Counter},
+			},
+			want: []string{
+				`metrics[0].name: "" is not a valid metric name`,
+				`metrics[1].name: "1st" is not a valid metric name`,
+				`metrics[2].name: "a-b" is not a valid metric name`,
+			},
+		},
+		{
+			name: "repeated name",
+			metrics: []Definition{
+				{Name: "a", Type: Counter},
+				{Name: "a", Type: Gauge},
+			},
+			want: []string{`metrics[1].name: "a" is already defined by metrics[0]`},
+		},
+		{
+			name: "types",
+			metrics: []Definition{
+				{Name: "a"},
+				{Name: "b", Type: "counter"},
+			},
+			want: []string{
+				"metrics[0].type: missing type",
+				`metrics[1].type: unknown type "counter", expected Counter, Fraction, Gauge, String, Time or Histogram`,
+			},
+		},
+		{
+			name:    "multi-line help",
+			metrics: []Definition{{Name: "a", Type: Counter, Help: "first\nsecond"}},
+			want:    []string{"metrics[0].help: the help text must be a single line"},
+		},
+		{
+			name:    "labels",
+			metrics: []Definition{{Name: "a", Type: Counter, Labels: []string{"ok", "not-ok", "__reserved", "ok"}}},
+			want: []string{
+				`metrics[0].labels[1]: "not-ok" is not a valid label name`,
+				"metrics[0].labels[2]: label names starting with __ are reserved",
+				`metrics[0].labels[3]: label "ok" is repeated`,
+			},
+		},
+		{
+			name: "buckets",
+			metrics: []Definition{
+				{Name: "a", Type: Counter, Buckets: []float64{1}},
+				{Name: "b", Type: Histogram, Buckets: []float64{1, 5, 5, 2}},
+			},
+			want: []string{
+				"metrics[0].buckets: only Histogram metrics have buckets",
+				"metrics[1].buckets[2]: buckets must be in increasing order, 5 follows 5",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Schema{Metrics: test.metrics}.validate("test.json")
+
+			got := schemaErrors(t, err)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got errors %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLSchema(t *testing.T) {
+	data := []byte(`
+metrics:
+  - name: http_requests_total
+    type: Counter
+    help: "HTTP requests served"
+    labels: [method, code]
+  - name: http_request_duration   # ms
+    type: Histogram
+    unit: ms
+    buckets:
+    - 5
+    - 10
+`)
+	want := Schema{Metrics: []Definition{
+		{Name: "http_requests_total", Type: Counter, Help: "HTTP requests served", Labels: []string{"method", "code"}},
+		{Name: "http_request_duration", Type: Histogram, Unit: "ms", Buckets: []float64{5, 10}},
+	}}
+
+	got, err := ParseYAML("test.yaml", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	_, err = ParseYAML("test.yaml", []byte("metrics:\n  - name: a\n    type: Counter\n    size: 1\n"))
+	if got := schemaErrors(t, err); !reflect.DeepEqual(got, []string{"size: unknown field"}) {
+		t.Fatalf("got errors %q, want the unknown field", got)
+	}
+}
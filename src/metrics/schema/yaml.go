@@ -0,0 +1,353 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package schema
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	errWrap "metrics/error"
+)
+
+// yamlLine Non-empty line of a YAML document, without its comment
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+// yamlParser Parser of the subset of YAML used by schemas: block mappings
+// and sequences, flow sequences of scalars, comments and scalars
+type yamlParser struct {
+	name  string
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML Parse a YAML document into the values encoding/json decodes
+// (map[string]interface{}, []interface{}, string, float64, bool and nil)
+//
+// name Name of the document in the errors
+func parseYAML(name string, data []byte) (interface{}, error) {
+	p := &yamlParser{name: name}
+	for i, text := range strings.Split(string(data), "\n") {
+		text = strings.TrimRight(stripComment(text), " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || (len(p.lines) == 0 && trimmed == "---") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, p.errorf(i+1, "tabs can not be used for indentation")
+		}
+		p.lines = append(p.lines, yamlLine{number: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(p.lines) == 0 {
+		return nil, p.errorf(1, "empty document")
+	}
+
+	document, err := p.parseNode(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, p.errorf(p.lines[p.pos].number, "unexpected indentation")
+	}
+	return document, nil
+}
+
+// errorf Error at a line of the document
+func (p *yamlParser) errorf(line int, reason string, args ...interface{}) error {
+	return errWrap.SchemaInvalid{Path: p.name, Field: fmt.Sprintf("line %d", line), Reason: fmt.Sprintf(reason, args...)}
+}
+
+// parseNode Parse the mapping or sequence starting at the current line
+func (p *yamlParser) parseNode(indent int) (interface{}, error) {
+	if isSequenceItem(p.lines[p.pos].text) {
+		return p.parseSequence(indent, false)
+	}
+	return p.parseMapping(indent)
+}
+
+// parseSequence Parse the items of a block sequence, ex. "- a"
+//
+// keyed Whether the sequence is the value of a mapping key at the same
+// indentation, it then ends at the next key
+func (p *yamlParser) parseSequence(indent int, keyed bool) (interface{}, error) {
+	items := []interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		line := p.lines[p.pos]
+		if !isSequenceItem(line.text) {
+			if keyed {
+				break
+			}
+			return nil, p.errorf(line.number, "expected a sequence item")
+		}
+
+		item := strings.TrimLeft(line.text[1:], " ")
+		switch {
+		case item == "":
+			// The item is the block on the next lines
+			p.pos++
+			value, err := p.parseChild(indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+
+		case isMappingEntry(item):
+			// The item is a mapping starting on this line, parsed as if
+			// its first key was on a line of its own
+			p.lines[p.pos] = yamlLine{number: line.number, indent: indent + len(line.text) - len(item), text: item}
+			value, err := p.parseMapping(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+
+		default:
+			value, err := p.parseValue(line.number, item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+			p.pos++
+		}
+
+		if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+			return nil, p.errorf(p.lines[p.pos].number, "unexpected indentation")
+		}
+	}
+	return items, nil
+}
+
+// parseMapping Parse the entries of a block mapping, ex. "key: value"
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	mapping := map[string]interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		line := p.lines[p.pos]
+		key, value, ok := splitMappingEntry(line.text)
+		if !ok {
+			return nil, p.errorf(line.number, "expected \"key: value\"")
+		}
+		key, err := p.parseKey(line.number, key)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := mapping[key]; ok {
+			return nil, p.errorf(line.number, "key %q is repeated", key)
+		}
+		p.pos++
+
+		if value == "" {
+			// The value is the block on the next lines, sequences can be
+			// at the same indentation as the key
+			if p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isSequenceItem(p.lines[p.pos].text) {
+				mapping[key], err = p.parseSequence(indent, true)
+			} else {
+				mapping[key], err = p.parseChild(indent)
+			}
+		} else {
+			mapping[key], err = p.parseValue(line.number, value)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+			return nil, p.errorf(p.lines[p.pos].number, "unexpected indentation")
+		}
+	}
+	return mapping, nil
+}
+
+// parseChild Parse the block indented under the previous line, null if
+// there is none
+func (p *yamlParser) parseChild(indent int) (interface{}, error) {
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return p.parseNode(p.lines[p.pos].indent)
+	}
+	return nil, nil
+}
+
+// parseKey Parse a mapping key, plain or quoted
+func (p *yamlParser) parseKey(line int, key string) (string, error) {
+	if key == "" || (key[0] != '"' && key[0] != '\'') {
+		return key, nil
+	}
+	value, err := p.parseValue(line, key)
+	if err != nil {
+		return "", err
+	}
+	return value.(string), nil
+}
+
+// parseValue Parse an inline value: a flow sequence of scalars or a scalar
+func (p *yamlParser) parseValue(line int, text string) (interface{}, error) {
+	switch text[0] {
+	case '[':
+		if !strings.HasSuffix(text, "]") {
+			return nil, p.errorf(line, "unterminated flow sequence")
+		}
+		items := []interface{}{}
+		elements, ok := splitFlow(text[1 : len(text)-1])
+		if !ok {
+			return nil, p.errorf(line, "unterminated quoted string")
+		}
+		for _, element := range elements {
+			if element == "" {
+				return nil, p.errorf(line, "empty flow sequence item")
+			}
+			if element[0] == '[' || element[0] == '{' {
+				return nil, p.errorf(line, "nested flow collections are not supported")
+			}
+			value, err := p.parseValue(line, element)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		}
+		return items, nil
+
+	case '{':
+		return nil, p.errorf(line, "flow mappings are not supported")
+
+	case '|', '>':
+		return nil, p.errorf(line, "block scalars are not supported")
+
+	case '&', '*', '!':
+		return nil, p.errorf(line, "anchors, aliases and tags are not supported")
+
+	case '"':
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, p.errorf(line, "invalid double-quoted string %s", text)
+		}
+		return value, nil
+
+	case '\'':
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, p.errorf(line, "unterminated single-quoted string")
+		}
+		inner := text[1 : len(text)-1]
+		if strings.Contains(strings.ReplaceAll(inner, "''", ""), "'") {
+			return nil, p.errorf(line, "invalid single-quoted string %s", text)
+		}
+		return strings.ReplaceAll(inner, "''", "'"), nil
+	}
+
+	switch text {
+	case "null", "Null", "NULL", "~":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if strings.ContainsAny(text[:1], "0123456789+-.") {
+		if number, err := strconv.ParseFloat(text, 64); err == nil && !math.IsInf(number, 0) && !math.IsNaN(number) {
+			return number, nil
+		}
+	}
+	return text, nil
+}
+
+// isSequenceItem Whether a line is a block sequence item
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// isMappingEntry Whether a text is a "key: value" mapping entry
+func isMappingEntry(text string) bool {
+	if text[0] == '[' || text[0] == '{' {
+		return false
+	}
+	_, _, ok := splitMappingEntry(text)
+	return ok
+}
+
+// splitMappingEntry Split a "key: value" mapping entry, on the first colon
+// followed by a space (or ending the text) outside of quotes
+func splitMappingEntry(text string) (key string, value string, ok bool) {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && i == 0:
+			quote = c
+		case c == ':' && (i == len(text)-1 || text[i+1] == ' '):
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// splitFlow Split the items of a flow sequence on the commas outside of
+// quotes
+//
+// returns false if a quoted string is not terminated
+func splitFlow(text string) ([]string, bool) {
+	if strings.TrimSpace(text) == "" {
+		return nil, true
+	}
+
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, strings.TrimSpace(text[start:i]))
+			start = i + 1
+		}
+	}
+	if quote != 0 {
+		return nil, false
+	}
+	return append(items, strings.TrimSpace(text[start:])), true
+}
+
+// stripComment Remove the comment of a line: from a # at its start or
+// after a space, outside of quotes
+func stripComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.ContainsRune(" \t[,:-", rune(text[i-1])) {
+				quote = c
+			}
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+	return text
+}
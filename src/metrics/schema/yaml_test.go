@@ -0,0 +1,209 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package schema
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	errWrap "metrics/error"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want interface{}
+		// Field and reason of the expected error, if any
+		errField  string
+		errReason string
+	}{
+		{
+			name: "mapping of scalars",
+			yaml: "a: 1\nb: text\nc: true\nd: ~\ne: -2.5\n",
+			want: map[string]interface{}{"a": 1.0, "b": "text", "c": true, "d": nil, "e": -2.5},
+		},
+		{
+			name: "document start and comments",
+			yaml: "---\n# schema\na: 1 # one\nb: x#y\n\n  # indented comment\n",
+			want: map[string]interface{}{"a": 1.0, "b": "x#y"},
+		},
+		{
+			name: "double quotes",
+			yaml: "a: \"x: y # z\"\nb: \"a\\tb\"\n\"quoted key\": 1\n",
+			want: map[string]interface{}{"a": "x: y # z", "b": "a\tb", "quoted key": 1.0},
+		},
+		{
+			name: "single quotes",
+			yaml: "a: 'it''s'\nb: '1'\nc: '#'\n",
+			want: map[string]interface{}{"a": "it's", "b": "1", "c": "#"},
+		},
+		{
+			name: "non-finite numbers are strings",
+			yaml: "a: .inf\nb: NaN\nc: 1e999\n",
+			want: map[string]interface{}{"a": ".inf", "b": "NaN", "c": "1e999"},
+		},
+		{
+			name: "flow sequences",
+			yaml: "a: [x, 'y, z', \"w\", 2]\nb: []\n",
+			want: map[string]interface{}{
+				"a": []interface{}{"x", "y, z", "w", 2.0},
+				"b": []interface{}{},
+			},
+		},
+		{
+			name: "block sequence of mappings",
+			yaml: "metrics:\n  - name: a\n    labels: [x]\n  - name: b\n",
+			want: map[string]interface{}{"metrics": []interface{}{
+				map[string]interface{}{"name": "a", "labels": []interface{}{"x"}},
+				map[string]interface{}{"name": "b"},
+			}},
+		},
+		{
+			name: "sequence at the indentation of its key",
+			yaml: "a:\n- 1\n- 2\nb: 3\n",
+			want: map[string]interface{}{"a": []interface{}{1.0, 2.0}, "b": 3.0},
+		},
+		{
+			name: "nested blocks",
+			yaml: "a:\n  b:\n    c: 1\n  d:\n    -\n      e: 2\n",
+			want: map[string]interface{}{"a": map[string]interface{}{
+				"b": map[string]interface{}{"c": 1.0},
+				"d": []interface{}{map[string]interface{}{"e": 2.0}},
+			}},
+		},
+		{
+			name: "missing value is null",
+			yaml: "a:\nb: 1\n",
+			want: map[string]interface{}{"a": nil, "b": 1.0},
+		},
+		{
+			name:      "empty document",
+			yaml:      "# nothing\n",
+			errField:  "line 1",
+			errReason: "empty document",
+		},
+		{
+			name:      "tab indentation",
+			yaml:      "a:\n\tb: 1\n",
+			errField:  "line 2",
+			errReason: "tabs can not be used for indentation",
+		},
+		{
+			name:      "deeper indentation",
+			yaml:      "a: 1\n  b: 2\n",
+			errField:  "line 2",
+			errReason: "unexpected indentation",
+		},
+		{
+			name:      "shallower indentation",
+			yaml:      "  a: 1\nb: 2\n",
+			errField:  "line 2",
+			errReason: "unexpected indentation",
+		},
+		{
+			name:      "sequence item in a mapping",
+			yaml:      "a: 1\n- b\n",
+			errField:  "line 2",
+			errReason: "expected \"key: value\"",
+		},
+		{
+			name:      "mapping entry in a sequence",
+			yaml:      "- a\nb: 1\n",
+			errField:  "line 2",
+			errReason: "expected a sequence item",
+		},
+		{
+			name:      "repeated key",
+			yaml:      "a: 1\na: 2\n",
+			errField:  "line 2",
+			errReason: "key \"a\" is repeated",
+		},
+		{
+			name:      "unterminated flow sequence",
+			yaml:      "a: [x, y\n",
+			errField:  "line 1",
+			errReason: "unterminated flow sequence",
+		},
+		{
+			name:      "unterminated quote in a flow sequence",
+			yaml:      "a: [x, 'y]\n",
+			errField:  "line 1",
+			errReason: "unterminated quoted string",
+		},
+		{
+			name:      "empty flow sequence item",
+			yaml:      "a: [x, , y]\n",
+			errField:  "line 1",
+			errReason: "empty flow sequence item",
+		},
+		{
+			name:      "nested flow sequence",
+			yaml:      "a: [[x]]\n",
+			errField:  "line 1",
+			errReason: "nested flow collections are not supported",
+		},
+		{
+			name:      "flow mapping",
+			yaml:      "a: {b: 1}\n",
+			errField:  "line 1",
+			errReason: "flow mappings are not supported",
+		},
+		{
+			name:      "block scalar",
+			yaml:      "a: |\n  text\n",
+			errField:  "line 1",
+			errReason: "block scalars are not supported",
+		},
+		{
+			name:      "anchor",
+			yaml:      "a: &x 1\n",
+			errField:  "line 1",
+			errReason: "anchors, aliases and tags are not supported",
+		},
+		{
+			name:      "invalid double-quoted string",
+			yaml:      "a: \"x\\q\"\n",
+			errField:  "line 1",
+			errReason: "invalid double-quoted string \"x\\q\"",
+		},
+		{
+			name:      "unterminated single-quoted string",
+			yaml:      "a: 'x\n",
+			errField:  "line 1",
+			errReason: "unterminated single-quoted string",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseYAML("test.yaml", []byte(test.yaml))
+			if test.errReason == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if !reflect.DeepEqual(got, test.want) {
+					t.Fatalf("got %#v, want %#v", got, test.want)
+				}
+				return
+			}
+
+			var schemaErr errWrap.SchemaInvalid
+			if !errors.As(err, &schemaErr) {
+				t.Fatalf("got %v (%#v), want a SchemaInvalid error", err, got)
+			}
+			if schemaErr.Path != "test.yaml" || schemaErr.Field != test.errField || schemaErr.Reason != test.errReason {
+				t.Fatalf("got error at %s %q, want at %s %q", schemaErr.Field, schemaErr.Reason, test.errField,
+					test.errReason)
+			}
+		})
+	}
+}
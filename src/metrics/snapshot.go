@@ -0,0 +1,263 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package metrics
+
+import (
+	"sort"
+	"time"
+)
+
+// metricValues Values and types of a set of metrics, shared by Snapshot and
+// Delta
+type metricValues struct {
+	values map[string]interface{}
+	types  map[string]MetricType
+}
+
+// GetMetricNames returns the sorted names of the metrics
+func (mv metricValues) GetMetricNames() []string {
+	metricNames := make([]string, 0, len(mv.values))
+	for metricName := range mv.values {
+		metricNames = append(metricNames, metricName)
+	}
+	sort.Strings(metricNames)
+	return metricNames
+}
+
+// ReadMetric returns the value of a metric
+//
+// returns false if the metric does not exist
+func (mv metricValues) ReadMetric(metricName string) (interface{}, bool) {
+	value, ok := mv.values[metricName]
+	return value, ok
+}
+
+// GetMetricType returns the type of a metric, InvalidMetric if it does not
+// exist
+func (mv metricValues) GetMetricType(metricName string) MetricType {
+	return mv.types[metricName]
+}
+
+// GetAllMetrics returns a copy of the mapping of metric name to value
+func (mv metricValues) GetAllMetrics() map[string]interface{} {
+	values := make(map[string]interface{}, len(mv.values))
+	for metricName, value := range mv.values {
+		values[metricName] = value
+	}
+	return values
+}
+
+// Snapshot Immutable, point-in-time copy of the values of a Metrics
+//
+// Callback metrics are evaluated when the Snapshot is taken, so it holds
+// the same values GetAllMetrics would return
+type Snapshot struct {
+	metricValues
+	time time.Time
+}
+
+// Time returns the time the Snapshot was taken at
+func (s Snapshot) Time() time.Time {
+	return s.time
+}
+
+// Delta Per-metric differences between two Snapshots of a Metrics
+//
+// - Counter: change since the previous Snapshot, negative if it went down
+// (ex. it was reset). The whole value if the metric is new
+// - Meter: MeterSnapshot whose Count is the increment and rates are current
+// - Histogram: Histogram of the values added since the previous Snapshot
+// - Time: time.Duration elapsed from the value to the end of the Delta
+// - Fraction, Gauge, String and Window: current value
+//
+// Metrics that do not exist in the current Snapshot are not included
+type Delta struct {
+	metricValues
+	start time.Time
+	end   time.Time
+}
+
+// Start returns the time of the previous Snapshot
+func (d Delta) Start() time.Time {
+	return d.start
+}
+
+// End returns the time of the current Snapshot
+func (d Delta) End() time.Time {
+	return d.end
+}
+
+// Snapshot returns an immutable copy of the values of the metrics, taken at
+// the current time of the clock of the Metrics
+func (metric Metrics) Snapshot() Snapshot {
+	metric.expireLazily()
+	metric.mutex.Lock()
+	now := metric.options.clock.Now()
+	metric.mutex.Unlock()
+
+	values, typeNames := metric.metricData.GetSnapshot()
+	types := make(map[string]MetricType, len(typeNames))
+	for metricName, typeName := range typeNames {
+		types[metricName] = metricCapabilitiesMap[typeName]
+	}
+
+	return Snapshot{
+		metricValues: metricValues{
+			values: values,
+			types:  types,
+		},
+		time: now,
+	}
+}
+
+// Delta returns the differences between the current values and a previous
+// Snapshot, along with the current Snapshot to pass to the next call
+//
+// Push exporters can send deltas this way without resetting the metrics:
+//
+//	previous := globalMetrics.Snapshot()
+//	for range ticker.C {
+//		var delta gometrics.Delta
+//		delta, previous = globalMetrics.Delta(previous)
+//		send(delta)
+//	}
+//
+// previous Snapshot taken earlier
+func (metric Metrics) Delta(previous Snapshot) (Delta, Snapshot) {
+	current := metric.Snapshot()
+	return current.Delta(previous), current
+}
+
+// Delta returns the differences between the Snapshot and a previous one
+//
+// previous Snapshot taken earlier
+func (s Snapshot) Delta(previous Snapshot) Delta {
+	delta := Delta{
+		metricValues: metricValues{
+			values: make(map[string]interface{}, len(s.values)),
+			types:  make(map[string]MetricType, len(s.types)),
+		},
+		start: previous.time,
+		end:   s.time,
+	}
+	for metricName, value := range s.values {
+		delta.values[metricName] = getDelta(value, previous.values[metricName], s.time)
+		delta.types[metricName] = s.types[metricName]
+	}
+	return delta
+}
+
+// getDelta Get the difference between the current and previous value of a
+// metric
+//
+// end Time of the current value
+func getDelta(current interface{}, previous interface{}, end time.Time) interface{} {
+	switch value := current.(type) {
+	case int:
+		// Negative if the Counter went down, ex. it was reset or decreased
+		if previousValue, ok := previous.(int); ok {
+			return value - previousValue
+		}
+		return value
+
+	case float64:
+		// Fraction and Gauge metrics are not cumulative
+		return value
+
+	case MeterSnapshot:
+		if previousValue, ok := previous.(MeterSnapshot); ok && previousValue.Count <= value.Count {
+			value.Count -= previousValue.Count
+		}
+		return value
+
+	case HistogramValue:
+		return getHistogramDelta(value, previous)
+
+	case TimerSnapshot:
+		return getTimerDelta(value, previous)
+
+	case RatioSnapshot:
+		// The windows are already the recent values
+		if previousValue, ok := previous.(RatioSnapshot); ok && previousValue.Denominator <= value.Denominator {
+			value.Numerator -= previousValue.Numerator
+			value.Denominator -= previousValue.Denominator
+			value.Ratio = 0
+			if value.Denominator > 0 {
+				value.Ratio = float64(value.Numerator) / float64(value.Denominator)
+			}
+		}
+		return value
+
+	case time.Time:
+		return end.Sub(value)
+
+	default:
+		return current
+	}
+}
+
+// getHistogramDelta Get the Histogram of the values added since the
+// previous one, the whole Histogram if the buckets changed or it went down
+func getHistogramDelta(current HistogramValue, previous interface{}) HistogramValue {
+	previousValue, ok := previous.(HistogramValue)
+	if !ok || len(previousValue.Counts) != len(current.Counts) || previousValue.Count > current.Count {
+		return current
+	}
+
+	delta := HistogramValue{
+		Buckets: current.Buckets,
+		Counts:  make([]int, len(current.Counts)),
+		Count:   current.Count - previousValue.Count,
+		Sum:     current.Sum - previousValue.Sum,
+	}
+	for i := range current.Counts {
+		delta.Counts[i] = current.Counts[i] - previousValue.Counts[i]
+	}
+	return delta
+}
+
+// getTimerDelta Get the durations observed since the previous TimerSnapshot,
+// the whole TimerSnapshot if the Timer was reset
+//
+// Min and Max are kept since the start, the mean and percentiles are those of
+// the durations observed since the previous TimerSnapshot.
+func getTimerDelta(current TimerSnapshot, previous interface{}) TimerSnapshot {
+	previousValue, ok := previous.(TimerSnapshot)
+	if !ok || previousValue.Count > current.Count {
+		return current
+	}
+
+	delta := current
+	delta.Count -= previousValue.Count
+	delta.Sum -= previousValue.Sum
+	delta.Histogram = getHistogramDelta(current.Histogram, previousValue.Histogram)
+	delta.Mean, delta.P50, delta.P90, delta.P99 = 0, 0, 0, 0
+	if delta.Count > 0 {
+		delta.Mean = delta.Sum / time.Duration(delta.Count)
+		delta.P50 = timerPercentile(delta, 0.5)
+		delta.P90 = timerPercentile(delta, 0.9)
+		delta.P99 = timerPercentile(delta, 0.99)
+	}
+	return delta
+}
+
+// timerPercentile Percentile of the Histogram of a TimerSnapshot, clamped to
+// its Min and Max
+func timerPercentile(snapshot TimerSnapshot, percentile float64) time.Duration {
+	value := time.Duration(snapshot.Histogram.Percentile(percentile) * float64(time.Millisecond))
+	if value < snapshot.Min {
+		return snapshot.Min
+	}
+	if value > snapshot.Max {
+		return snapshot.Max
+	}
+	return value
+}
@@ -0,0 +1,69 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package telemetry
+
+import (
+	"runtime/metrics"
+
+	gometrics "metrics"
+)
+
+// Names of the runtime/metrics samples of the heap allocations
+const (
+	allocBytesSample   = "/gc/heap/allocs:bytes"
+	allocObjectsSample = "/gc/heap/allocs:objects"
+)
+
+// allocations Cumulative heap allocations of the process at some point
+//
+// The Go runtime does not keep per-goroutine counters, so the allocations of
+// a call are the process-wide delta between its start and end, including
+// the allocations of the other goroutines. They are approximate even for
+// synchronous calls: the runtime only adds the small allocations to the
+// counters when a span of the allocating P is refilled, so a call often
+// reads 0 and a later one gets the whole span (ex. 8KB) at once.
+type allocations struct {
+	tracked bool
+	bytes   uint64
+	objects uint64
+}
+
+// readAllocations Read the current heap allocations of the process
+func readAllocations() allocations {
+	samples := [2]metrics.Sample{{Name: allocBytesSample}, {Name: allocObjectsSample}}
+	metrics.Read(samples[:])
+
+	current := allocations{tracked: true}
+	if samples[0].Value.Kind() == metrics.KindUint64 {
+		current.bytes = samples[0].Value.Uint64()
+	}
+	if samples[1].Value.Kind() == metrics.KindUint64 {
+		current.objects = samples[1].Value.Uint64()
+	}
+	return current
+}
+
+// setAllocations Set the allocations made since start on a call outcome
+//
+// Nothing is set when the allocations were not tracked at the start
+func setAllocations(outcome *gometrics.FunctionOutcome, start allocations) {
+	if !start.tracked {
+		return
+	}
+
+	end := readAllocations()
+	if end.bytes > start.bytes {
+		outcome.AllocBytes = end.bytes - start.bytes
+	}
+	if end.objects > start.objects {
+		outcome.AllocObjects = end.objects - start.objects
+	}
+}
@@ -0,0 +1,293 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package telemetry
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	gometrics "metrics"
+)
+
+// Separator between the functions of a call path
+const CallPathSeparator = " > "
+
+// DiffOptions Options to compare two telemetry snapshots
+type DiffOptions struct {
+	// RegressionThreshold Relative increase of the average time flagged as
+	// a regression (ex. 0.1 for 10%)
+	RegressionThreshold float64
+	// MinRegressionMs Smallest average time increase (ms) flagged as a
+	// regression, avoids flagging noise such as 1ms -> 2ms
+	MinRegressionMs float64
+}
+
+// DefaultDiffOptions Get the default options: regressions are average time
+// increases over 10% and at least 1ms
+func DefaultDiffOptions() DiffOptions {
+	return DiffOptions{
+		RegressionThreshold: 0.1,
+		MinRegressionMs:     1,
+	}
+}
+
+// CallPathStats Aggregated statistics of every call to a call path
+type CallPathStats struct {
+	Calls         int
+	TotalTimeMs   int
+	AverageTimeMs float64
+	MaxTimeMs     int
+}
+
+// CallPathDiff Change of a call path between two snapshots
+//
+// Before is nil for added paths and After is nil for removed ones
+type CallPathDiff struct {
+	Path               string
+	Before             *CallPathStats
+	After              *CallPathStats
+	CallsDelta         int
+	TotalTimeMsDelta   int
+	AverageTimeMsDelta float64
+	MaxTimeMsDelta     int
+	Regression         bool
+}
+
+// Diff Result of comparing two telemetry snapshots
+type Diff struct {
+	Options     DiffOptions
+	Changed     []*CallPathDiff
+	Added       []*CallPathDiff
+	Removed     []*CallPathDiff
+	Regressions []*CallPathDiff
+}
+
+// DiffMetricsJSON Compare two outputs of GetMetricsJSON
+//
+// Nodes are aligned by call path (the chain of function names from the root)
+// with the call ID suffixes stripped, every call to the same path is
+// aggregated.
+//
+// before Baseline telemetry JSON
+// after Telemetry JSON to compare against the baseline
+// options Regression criteria
+// returns error if any of the JSONs cannot be parsed
+func DiffMetricsJSON(before string, after string, options DiffOptions) (*Diff, error) {
+	beforeTree := gometrics.FunctionTracerMetricsDTO{}
+	if err := json.Unmarshal([]byte(before), &beforeTree); err != nil {
+		return nil, fmt.Errorf("Unable to parse the baseline telemetry JSON: %w", err)
+	}
+	afterTree := gometrics.FunctionTracerMetricsDTO{}
+	if err := json.Unmarshal([]byte(after), &afterTree); err != nil {
+		return nil, fmt.Errorf("Unable to parse the telemetry JSON: %w", err)
+	}
+
+	return DiffMetrics(&beforeTree, &afterTree, options), nil
+}
+
+// DiffMetrics Compare two telemetry trees
+//
+// before Baseline tree
+// after Tree to compare against the baseline
+// options Regression criteria
+func DiffMetrics(before *gometrics.FunctionTracerMetricsDTO, after *gometrics.FunctionTracerMetricsDTO,
+	options DiffOptions) *Diff {
+	beforePaths := GetCallPathStats(before)
+	afterPaths := GetCallPathStats(after)
+
+	diff := &Diff{
+		Options:     options,
+		Changed:     []*CallPathDiff{},
+		Added:       []*CallPathDiff{},
+		Removed:     []*CallPathDiff{},
+		Regressions: []*CallPathDiff{},
+	}
+	for path, afterStats := range afterPaths {
+		beforeStats, ok := beforePaths[path]
+		if !ok {
+			diff.Added = append(diff.Added, &CallPathDiff{Path: path, After: afterStats})
+			continue
+		}
+
+		pathDiff := &CallPathDiff{
+			Path:               path,
+			Before:             beforeStats,
+			After:              afterStats,
+			CallsDelta:         afterStats.Calls - beforeStats.Calls,
+			TotalTimeMsDelta:   afterStats.TotalTimeMs - beforeStats.TotalTimeMs,
+			AverageTimeMsDelta: afterStats.AverageTimeMs - beforeStats.AverageTimeMs,
+			MaxTimeMsDelta:     afterStats.MaxTimeMs - beforeStats.MaxTimeMs,
+		}
+		pathDiff.Regression = isRegression(pathDiff, options)
+		diff.Changed = append(diff.Changed, pathDiff)
+		if pathDiff.Regression {
+			diff.Regressions = append(diff.Regressions, pathDiff)
+		}
+	}
+	for path, beforeStats := range beforePaths {
+		if _, ok := afterPaths[path]; !ok {
+			diff.Removed = append(diff.Removed, &CallPathDiff{Path: path, Before: beforeStats})
+		}
+	}
+
+	for _, pathDiffs := range [][]*CallPathDiff{diff.Changed, diff.Added, diff.Removed, diff.Regressions} {
+		sort.Slice(pathDiffs, func(i, j int) bool {
+			return pathDiffs[i].Path < pathDiffs[j].Path
+		})
+	}
+	return diff
+}
+
+// GetCallPathStats Aggregate the calls of a tree by call path
+//
+// The root itself is not part of the paths, ex. "main.taskA() > main.taskB()"
+func GetCallPathStats(tree *gometrics.FunctionTracerMetricsDTO) map[string]*CallPathStats {
+	paths := map[string]*CallPathStats{}
+	for _, child := range tree.Children {
+		addCallPathStats(paths, "", child)
+	}
+	for _, stats := range paths {
+		if stats.Calls > 0 {
+			stats.AverageTimeMs = float64(stats.TotalTimeMs) / float64(stats.Calls)
+		}
+	}
+	return paths
+}
+
+// addCallPathStats Add a node (and its children) to the call path statistics
+func addCallPathStats(paths map[string]*CallPathStats, parentPath string, node *gometrics.FunctionTracerMetricsDTO) {
+	path := gometrics.GetName(node.Function)
+	if parentPath != "" {
+		path = parentPath + CallPathSeparator + path
+	}
+
+	stats, ok := paths[path]
+	if !ok {
+		stats = &CallPathStats{}
+		paths[path] = stats
+	}
+	stats.Calls += node.Calls
+	stats.TotalTimeMs += node.TotalTimeMs
+	if node.HigherCeiling > stats.MaxTimeMs {
+		stats.MaxTimeMs = node.HigherCeiling
+	}
+
+	for _, child := range node.Children {
+		addCallPathStats(paths, path, child)
+	}
+}
+
+// isRegression Check whether a change is past the regression threshold
+func isRegression(pathDiff *CallPathDiff, options DiffOptions) bool {
+	if pathDiff.AverageTimeMsDelta < options.MinRegressionMs || pathDiff.AverageTimeMsDelta <= 0 {
+		return false
+	}
+	if pathDiff.Before.AverageTimeMs == 0 {
+		return true
+	}
+	return pathDiff.AverageTimeMsDelta/pathDiff.Before.AverageTimeMs > options.RegressionThreshold
+}
+
+// JSON Get the diff as a JSON string
+func (d *Diff) JSON() (string, error) {
+	diffJSON, err := json.Marshal(d)
+	if err != nil {
+		return "", err
+	}
+	return string(diffJSON), nil
+}
+
+// Text Get the diff as a human readable table
+func (d *Diff) Text() string {
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, "Regressions (average time +%.0f%% and +%gms): %d\n",
+		100*d.Options.RegressionThreshold, d.Options.MinRegressionMs, len(d.Regressions))
+
+	writer := tabwriter.NewWriter(builder, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "\nPATH\tCALLS\tTOTAL (ms)\tAVERAGE (ms)\tMAX (ms)\t")
+	for _, pathDiff := range d.Changed {
+		marker := ""
+		if pathDiff.Regression {
+			marker = "REGRESSION"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", pathDiff.Path,
+			formatChange(float64(pathDiff.Before.Calls), float64(pathDiff.After.Calls)),
+			formatChange(float64(pathDiff.Before.TotalTimeMs), float64(pathDiff.After.TotalTimeMs)),
+			formatChange(pathDiff.Before.AverageTimeMs, pathDiff.After.AverageTimeMs),
+			formatChange(float64(pathDiff.Before.MaxTimeMs), float64(pathDiff.After.MaxTimeMs)),
+			marker)
+	}
+	writer.Flush()
+
+	for _, section := range []struct {
+		title     string
+		pathDiffs []*CallPathDiff
+	}{{"Added", d.Added}, {"Removed", d.Removed}} {
+		fmt.Fprintf(builder, "\n%s call paths: %d\n", section.title, len(section.pathDiffs))
+		for _, pathDiff := range section.pathDiffs {
+			fmt.Fprintf(builder, "  %s\n", pathDiff.Path)
+		}
+	}
+	return builder.String()
+}
+
+// Markdown Get the diff as markdown, ex. for a PR comment
+func (d *Diff) Markdown() string {
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, "### Telemetry diff\n\n")
+	fmt.Fprintf(builder, "**%d regression(s)** (average time +%.0f%% and +%gms)\n\n",
+		len(d.Regressions), 100*d.Options.RegressionThreshold, d.Options.MinRegressionMs)
+
+	if len(d.Changed) > 0 {
+		fmt.Fprintf(builder, "| Call path | Calls | Total (ms) | Average (ms) | Max (ms) | |\n")
+		fmt.Fprintf(builder, "|---|---|---|---|---|---|\n")
+		for _, pathDiff := range d.Changed {
+			marker := ""
+			if pathDiff.Regression {
+				marker = ":warning: regression"
+			}
+			fmt.Fprintf(builder, "| `%s` | %s | %s | %s | %s | %s |\n", pathDiff.Path,
+				formatChange(float64(pathDiff.Before.Calls), float64(pathDiff.After.Calls)),
+				formatChange(float64(pathDiff.Before.TotalTimeMs), float64(pathDiff.After.TotalTimeMs)),
+				formatChange(pathDiff.Before.AverageTimeMs, pathDiff.After.AverageTimeMs),
+				formatChange(float64(pathDiff.Before.MaxTimeMs), float64(pathDiff.After.MaxTimeMs)),
+				marker)
+		}
+	}
+
+	for _, section := range []struct {
+		title     string
+		pathDiffs []*CallPathDiff
+	}{{"Added", d.Added}, {"Removed", d.Removed}} {
+		if len(section.pathDiffs) == 0 {
+			continue
+		}
+		fmt.Fprintf(builder, "\n**%s call paths**\n\n", section.title)
+		for _, pathDiff := range section.pathDiffs {
+			fmt.Fprintf(builder, "- `%s`\n", pathDiff.Path)
+		}
+	}
+	return builder.String()
+}
+
+// formatChange Format a before -> after change with its relative delta
+func formatChange(before float64, after float64) string {
+	if before == after {
+		return fmt.Sprintf("%g", after)
+	}
+	if before == 0 {
+		return fmt.Sprintf("%g -> %g", before, after)
+	}
+	return fmt.Sprintf("%g -> %g (%+.1f%%)", before, after, 100*(after-before)/before)
+}
@@ -12,11 +12,14 @@ package telemetry
 
 import (
 	"encoding/json"
+	"reflect"
 	"runtime"
+	"runtime/debug"
 	"sync"
 	"time"
 	"strings"
 	gometrics "metrics"
+	"metrics/clock"
 )
 
 //////////////////////////////////////////////////////////
@@ -25,6 +28,11 @@ type Context struct{
 	ParentFunctionName string
 	FunctionName       string 
 	CallID             string  
+	// Start Time at which the traced function was entered
+	Start              time.Time
+
+	// Heap allocations at the start of the call, when tracked
+	allocations allocations
 }
 
 // Utility functions
@@ -33,12 +41,24 @@ type Context struct{
 //
 // Ex. rdlabs.hpecorp.net/restlib/table.(*RowGetter).GetRows
 func FunctionName(context Context) (Context) {
+	return newChildContext(context, getFunctionName(2))
+}
+
+// newChildContext Creates the context of a traced call made from context
+//
+// context Context of the caller
+// functionName Name of the traced function, without the call ID suffix
+func newChildContext(context Context, functionName string) (Context) {
 
 	newContext := Context{
 		ParentFunctionName: context.FunctionName,
-		FunctionName: getFunctionName(2),
+		FunctionName: functionName,
 		CallID: context.CallID,
 	}
+	// The clock is only read while tracing
+	if globalTelemetry.IsEnabled() {
+		newContext.Start = Now()
+	}
 
 	//When the ID is empty it means we're creating 
 	//a new child of the main function so we get a new ID
@@ -52,9 +72,13 @@ func FunctionName(context Context) (Context) {
 	}
 	
 	//add id to function names
-	newContext.ParentFunctionName = newContext.ParentFunctionName[:strings.Index(newContext.ParentFunctionName,")")+1] + 
-									newContext.CallID 
+	newContext.ParentFunctionName = gometrics.GetName(newContext.ParentFunctionName) + newContext.CallID
 	newContext.FunctionName += newContext.CallID 
+
+	// Sampled last so the work above is not counted
+	if globalTelemetry.IsTrackingAllocations() {
+		newContext.allocations = readAllocations()
+	}
 	return newContext
 }
 
@@ -69,18 +93,80 @@ func getFunctionName(skips int)(functionName string){
 	return "N/A"
 }
 
+// Maximum number of stack frames kept when a traced function panics
+const maxPanicStackFrames = 16
+
+// getPanicStack Obtains the stack trace of the in-flight panic
+//
+// The frames belonging to the telemetry hooks and to the runtime panic
+// machinery are removed, so the first frame is the one that panicked
+func getPanicStack() string {
+	lines := strings.Split(strings.TrimSpace(string(debug.Stack())), "\n")
+
+	// Every frame takes two lines (function and file:line) after the
+	// goroutine header, skip up to the last runtime's panic() frame since
+	// callers see the panic resumed by their children's hooks
+	frames := lines[1:]
+	panicFrame := -1
+	for i := 0; i+1 < len(frames); i += 2 {
+		if strings.HasPrefix(frames[i], "panic(") {
+			panicFrame = i
+		}
+	}
+	frames = frames[panicFrame+2:]
+	if len(frames) > 2*maxPanicStackFrames {
+		frames = frames[:2*maxPanicStackFrames]
+	}
+
+	return strings.Join(frames, "\n")
+}
+
+// resumePanic Resumes a panic recovered by the hook of a traced function,
+// once its outcome is recorded
+func resumePanic(panicValue interface{}) {
+	panic(panicValue)
+}
+
+// resumePanicName Name of resumePanic in the stack traces
+var resumePanicName = runtime.FuncForPC(reflect.ValueOf(resumePanic).Pointer()).Name()
+
+// isResumedPanic Obtains whether the in-flight panic was resumed by the hook
+// of a traced callee, which already recorded it
+//
+// Only the innermost traced function records the panic, the traced functions
+// it then unwinds through record their call as unwound. The panic value is
+// resumed as is, so the code recovering it is not affected.
+func isResumedPanic() bool {
+	lines := strings.Split(strings.TrimSpace(string(debug.Stack())), "\n")
+
+	// The first runtime's panic() frame is the panic being handled, the
+	// next frame is the function that called it
+	frames := lines[1:]
+	for i := 0; i+2 < len(frames); i += 2 {
+		if strings.HasPrefix(frames[i], "panic(") {
+			return strings.HasPrefix(frames[i+2], resumePanicName+"(")
+		}
+	}
+	return false
+}
+
 //////////////////////////////////////////////////////////
 
 // Telemetry object
-type telemetry struct {
+type Telemetry struct {
 	sync.Mutex
-	enabled        bool
-	functionTracer *gometrics.FunctionTracer
+	enabled          bool
+	trackAllocations bool
+	functionTracer   *gometrics.FunctionTracer
+
+	// Optional counter increased on every traced panic
+	panicMetrics    *gometrics.Metrics
+	panicMetricName string
 }
 
 // NewTelemetry Create a new Telemetry object
-func NewTelemetry() *telemetry {
-	return &telemetry{
+func NewTelemetry() *Telemetry {
+	return &Telemetry{
 		Mutex:          sync.Mutex{},
 		enabled:        false,
 		functionTracer: gometrics.NewFunctionTracer(),
@@ -88,7 +174,7 @@ func NewTelemetry() *telemetry {
 }
 
 // Enable Enable metrics collection by the Telemetry object
-func (t *telemetry) Enable() {
+func (t *Telemetry) Enable() {
 	t.Lock()
 	defer t.Unlock()
 
@@ -96,7 +182,7 @@ func (t *telemetry) Enable() {
 }
 
 // Disable Disable metrics collection by the Telemetry object
-func (t *telemetry) Disable() {
+func (t *Telemetry) Disable() {
 	t.Lock()
 	defer t.Unlock()
 
@@ -105,13 +191,13 @@ func (t *telemetry) Disable() {
 }
 
 // Clear Clear metrics collected by the Telemetry object
-func (t *telemetry) Clear() {
+func (t *Telemetry) Clear() {
 	t.functionTracer.Clear()
 }
 
 // GetMetricsJSON Get a JSON array containing all per-function collected metrics
-func (t *telemetry) GetMetricsJSON() string {
-	functionTracerMetrics := globalTelemetry.functionTracer.GetFunctionTracerMetrics()
+func (t *Telemetry) GetMetricsJSON() string {
+	functionTracerMetrics := t.functionTracer.GetFunctionTracerMetrics()
 	telemetryMetricsJSON, err := json.Marshal(functionTracerMetrics)
 	if err != nil {
 		return "{\"error\": \"Could not marshal the telemetry metrics\"}"
@@ -120,30 +206,164 @@ func (t *telemetry) GetMetricsJSON() string {
 	return string(telemetryMetricsJSON)
 }
 
+// GetMetrics Get the tree of per-function collected metrics
+func (t *Telemetry) GetMetrics() gometrics.FunctionTracerMetricsDTO {
+	return t.functionTracer.GetFunctionTracerMetrics()
+}
+
+// GetCriticalPaths Get the critical path of every call made from the root
+func (t *Telemetry) GetCriticalPaths() []*gometrics.CriticalPathDTO {
+	tree := t.functionTracer.GetFunctionTracerMetrics()
+	criticalPaths := []*gometrics.CriticalPathDTO{}
+	for _, call := range tree.Children {
+		criticalPaths = append(criticalPaths, call.CriticalPath)
+	}
+
+	return criticalPaths
+}
+
 // IsEnabled Get whether metrics collection is enabled or not
-func (t *telemetry) IsEnabled() bool {
+func (t *Telemetry) IsEnabled() bool {
 	// A mutex here won't help _much_ for now but will be costly
 	// so leave it unprotected
 	return t.enabled
 }
 
-func (t* telemetry) SetRoot(root string) {
+func (t *Telemetry) SetRoot(root string) {
 	t.functionTracer.SetRoot(root)
 }
 
-func (t* telemetry) GetRoot() (string) {
+func (t *Telemetry) GetRoot() (string) {
 	return t.functionTracer.GetRoot()
 }
 
 // IncreaseFunctionTracer Increase/update the traced function metrics
-func (t *telemetry) IncreaseFunctionTracer(context Context, start time.Time) {
-	t.functionTracer.IncreaseFunctionTracer(context.ParentFunctionName, context.FunctionName, start)
+//
+// context Context returned by FunctionName for the traced function
+// start Deprecated: the Start of the context is used, since the end time comes
+// from the Telemetry clock too. It is only used if the context has no Start
+// (ex. it was created while the Telemetry was disabled)
+func (t *Telemetry) IncreaseFunctionTracer(context Context, start time.Time) {
+	if !context.Start.IsZero() {
+		start = context.Start
+	}
+	outcome := gometrics.FunctionOutcome{}
+	setAllocations(&outcome, context.allocations)
+	t.functionTracer.IncreaseFunctionTracerOutcome(context.ParentFunctionName, context.FunctionName, start, outcome)
+}
+
+// End Increase/update the traced function metrics along with the call outcome
+//
+// context Context returned by FunctionName for the traced function
+// outcome Result of the call (returned error or panic)
+func (t *Telemetry) End(context Context, outcome gometrics.FunctionOutcome) {
+	// Contexts created while the Telemetry was disabled have no start time,
+	// their calls are recorded without a duration
+	if context.Start.IsZero() {
+		context.Start = t.Now()
+	}
+	setAllocations(&outcome, context.allocations)
+	t.functionTracer.IncreaseFunctionTracerOutcome(context.ParentFunctionName, context.FunctionName, context.Start,
+		outcome)
+
+	if outcome.Panic == nil {
+		return
+	}
+
+	t.Lock()
+	panicMetrics, panicMetricName := t.panicMetrics, t.panicMetricName
+	t.Unlock()
+	if panicMetrics != nil {
+		// Nothing sensible to do with the error while panicking
+		_ = panicMetrics.IncreaseMetricValue(panicMetricName, 1)
+	}
+}
+
+// SetPanicCounter Set a Counter metric to be increased on every traced panic
+//
+// metrics Metrics containing the counter, nil to stop counting panics
+// metricName Name of the Counter metric
+func (t *Telemetry) SetPanicCounter(metrics *gometrics.Metrics, metricName string) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.panicMetrics = metrics
+	t.panicMetricName = metricName
+}
+
+// SetClock Set the clock used to time the traced calls
+func (t *Telemetry) SetClock(clock clock.Clock) {
+	t.functionTracer.SetClock(clock)
+}
+
+// Now Get the current time of the Telemetry clock
+func (t *Telemetry) Now() time.Time {
+	return t.functionTracer.Now()
+}
+
+// SetMaxErrorMessages Set the size of the top-N error message table
+func (t *Telemetry) SetMaxErrorMessages(maxErrorMessages int) {
+	t.functionTracer.SetMaxErrorMessages(maxErrorMessages)
+}
+
+// SetWindows Set the sliding windows kept for every traced function
+//
+// spans Lengths of the windows (ex. time.Minute, 5*time.Minute), none to
+// stop keeping windows
+func (t *Telemetry) SetWindows(spans ...time.Duration) {
+	t.functionTracer.SetWindows(spans...)
+}
+
+// SetMaxNodes Set the maximum number of nodes of the call tree, the calls
+// past it are aggregated in an "overflow()" node
+//
+// maxNodes Maximum number of nodes, 0 for no limit
+func (t *Telemetry) SetMaxNodes(maxNodes int) {
+	t.functionTracer.SetMaxNodes(maxNodes)
+}
+
+// GetRejectedCalls Get the number of calls aggregated in the overflow node
+func (t *Telemetry) GetRejectedCalls() int {
+	return t.functionTracer.GetRejectedCalls()
+}
+
+// GetFunctionWindows Get the sliding windows of every traced function
+func (t *Telemetry) GetFunctionWindows() map[string][]gometrics.WindowSnapshot {
+	return t.functionTracer.GetFunctionWindows()
+}
+
+// SetAllocationTracking Enable or disable the tracking of the heap
+// allocations made by the traced calls
+//
+// It reads the runtime allocation counters at the start and end of every
+// call, which adds some overhead, so it starts out disabled. The counts are
+// approximate, process-wide and advance a span at a time (see allocations).
+func (t *Telemetry) SetAllocationTracking(enabled bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.trackAllocations = enabled
+}
+
+// IsTrackingAllocations Get whether allocation tracking is enabled or not
+func (t *Telemetry) IsTrackingAllocations() bool {
+	// Read on every traced call, unprotected like IsEnabled
+	return t.enabled && t.trackAllocations
+}
+
+// GetFoldedStacks Get the traced calls in the folded stacks format of flame
+// graph tools
+//
+// weight Statistic used as the value of the calls (ex. gometrics.FlameAllocBytes)
+func (t *Telemetry) GetFoldedStacks(weight gometrics.FlameWeight) string {
+	tree := t.functionTracer.GetFunctionTracerMetrics()
+	return gometrics.GetFoldedStacks(&tree, weight)
 }
 
 //////////////////////////////////////////////////////////
 
 // Global Telemetry object
-var globalTelemetry *telemetry
+var globalTelemetry *Telemetry
 
 // Telemetry public API
 
@@ -154,6 +374,21 @@ func init() {
 	globalTelemetry = NewTelemetry()
 }
 
+// SetGlobal Replace the global Telemetry object
+//
+// Meant for tests that need an isolated Telemetry (see telemetrytest)
+//
+// The swap is not synchronized with the functions reading the global
+// Telemetry: call it before the traced code starts and after it returns,
+// never while other goroutines may be tracing calls
+//
+// returns the previous global Telemetry object so it can be restored
+func SetGlobal(t *Telemetry) (previous *Telemetry) {
+	previous = globalTelemetry
+	globalTelemetry = t
+	return previous
+}
+
 // Telemetry global instance API
 
 // Leave the infra open for eventual non-global telemetry instances by
@@ -193,12 +428,163 @@ func GetMetricsJSON() string {
 	return globalTelemetry.GetMetricsJSON()
 }
 
+// GetCriticalPaths Get the critical path of every call made from the global
+// Telemetry root
+func GetCriticalPaths() []*gometrics.CriticalPathDTO {
+	return globalTelemetry.GetCriticalPaths()
+}
+
 // IsEnabled Get whether global Telemetry metrics collection is enabled or not
 func IsEnabled() bool {
 	return globalTelemetry.IsEnabled()
 }
 
 // IncreaseFunctionTracer Increase/update global Telemetry traced function metrics
+//
+// When deferred from a panicking function the panic is recorded on the node
+// and then resumed. The traced callers it unwinds through record their call
+// as unwound, so the panic is only counted once.
+//
+// context Context returned by FunctionName for the traced function
+// start Deprecated: the Start of the context is used, since the end time comes
+// from the Telemetry clock too. It is only used if the context has no Start
 func IncreaseFunctionTracer(context Context, start time.Time) {
+	// recover() only works when called straight from the deferred function
+	if panicValue := recover(); panicValue != nil {
+		if context.Start.IsZero() {
+			context.Start = start
+		}
+		outcome := gometrics.FunctionOutcome{Unwound: true}
+		if !isResumedPanic() {
+			outcome = gometrics.FunctionOutcome{
+				Panic:      panicValue,
+				PanicStack: getPanicStack(),
+			}
+		}
+		globalTelemetry.End(context, outcome)
+		resumePanic(panicValue)
+	}
+
 	globalTelemetry.IncreaseFunctionTracer(context, start)
 }
+
+// End Increase/update global Telemetry traced function metrics along with
+// the call outcome
+//
+// Meant to be deferred with a pointer to the named error return value so the
+// final error is read when the traced function returns:
+//
+//	func task(context telemetry.Context) (err error) {
+//		newContext := telemetry.FunctionName(context)
+//		defer telemetry.End(newContext, &err)
+//		...
+//	}
+//
+// If the traced function panics, the panic value and stack are recorded on
+// the node, the panic counter (if any) is increased and the panic is resumed.
+// The traced callers it unwinds through record their call as unwound, so the
+// panic is only counted once.
+//
+// context Context returned by FunctionName for the traced function
+// err Pointer to the returned error, nil when the function has none
+func End(context Context, err *error) {
+	// recover() only works when called straight from the deferred function
+	panicValue := recover()
+	if !IsEnabled() {
+		if panicValue != nil {
+			panic(panicValue)
+		}
+		return
+	}
+
+	outcome := gometrics.FunctionOutcome{}
+	if err != nil {
+		outcome.Err = *err
+	}
+	switch {
+	case panicValue != nil && isResumedPanic():
+		outcome.Unwound = true
+	case panicValue != nil:
+		outcome.Panic = panicValue
+		outcome.PanicStack = getPanicStack()
+	}
+	globalTelemetry.End(context, outcome)
+
+	if panicValue != nil {
+		resumePanic(panicValue)
+	}
+}
+
+// SetPanicCounter Set a Counter metric to be increased on every panic traced
+// by the global Telemetry
+//
+// metrics Metrics containing the counter, nil to stop counting panics
+// metricName Name of the Counter metric
+func SetPanicCounter(metrics *gometrics.Metrics, metricName string) {
+	globalTelemetry.SetPanicCounter(metrics, metricName)
+}
+
+// SetClock Set the clock used by the global Telemetry to time the traced
+// calls
+//
+// Tests can use a clock.Manual to get deterministic timings, the calls are
+// timed from the Start of their context, read from the same clock:
+//
+//	defer telemetry.IncreaseFunctionTracer(newContext, newContext.Start)
+func SetClock(clock clock.Clock) {
+	globalTelemetry.SetClock(clock)
+}
+
+// Now Get the current time of the global Telemetry clock
+func Now() time.Time {
+	return globalTelemetry.Now()
+}
+
+// SetMaxErrorMessages Set the size of the global Telemetry top-N error
+// message table
+func SetMaxErrorMessages(maxErrorMessages int) {
+	globalTelemetry.SetMaxErrorMessages(maxErrorMessages)
+}
+
+// SetAllocationTracking Enable or disable the tracking of the heap
+// allocations made by the calls traced by the global Telemetry
+func SetAllocationTracking(enabled bool) {
+	globalTelemetry.SetAllocationTracking(enabled)
+}
+
+// GetFoldedStacks Get the calls traced by the global Telemetry in the folded
+// stacks format of flame graph tools
+//
+// weight Statistic used as the value of the calls (ex. gometrics.FlameAllocBytes)
+func GetFoldedStacks(weight gometrics.FlameWeight) string {
+	return globalTelemetry.GetFoldedStacks(weight)
+}
+
+// SetWindows Set the sliding windows kept by the global Telemetry for every
+// traced function
+//
+// spans Lengths of the windows (ex. time.Minute, 5*time.Minute), none to
+// stop keeping windows
+func SetWindows(spans ...time.Duration) {
+	globalTelemetry.SetWindows(spans...)
+}
+
+// GetFunctionWindows Get the sliding windows of every function traced by the
+// global Telemetry (ex. the calls and p99 time over the last 5 minutes)
+func GetFunctionWindows() map[string][]gometrics.WindowSnapshot {
+	return globalTelemetry.GetFunctionWindows()
+}
+
+// SetMaxNodes Set the maximum number of nodes of the global Telemetry call
+// tree, the calls past it are aggregated in an "overflow()" node
+//
+// maxNodes Maximum number of nodes, 0 for no limit
+func SetMaxNodes(maxNodes int) {
+	globalTelemetry.SetMaxNodes(maxNodes)
+}
+
+// GetRejectedCalls Get the number of calls aggregated in the overflow node of
+// the global Telemetry
+func GetRejectedCalls() int {
+	return globalTelemetry.GetRejectedCalls()
+}
@@ -0,0 +1,90 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package telemetrytest
+
+import (
+	"reflect"
+	"testing"
+
+	gometrics "metrics"
+)
+
+// AssertMetric Assert the value of a metric
+//
+// metrics Metrics containing the metric
+// metricName Name of the metric
+// want Expected value, of the same type as the metric (ex. int for Counter)
+func AssertMetric(tb testing.TB, metrics *gometrics.Metrics, metricName string, want interface{}) {
+	tb.Helper()
+
+	got, err := metrics.ReadMetric(metricName)
+	if err != nil {
+		tb.Errorf("metrics: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		tb.Errorf("metrics: %s = %v (%T), want %v (%T)", metricName, got, got, want, want)
+	}
+}
+
+// MetricsRecorder Values of a Metrics at some point in time, used to assert
+// how much they changed afterwards
+type MetricsRecorder struct {
+	tb      testing.TB
+	metrics *gometrics.Metrics
+	values  map[string]interface{}
+}
+
+// RecordMetrics Record the current values of a Metrics
+func RecordMetrics(tb testing.TB, metrics *gometrics.Metrics) *MetricsRecorder {
+	values := map[string]interface{}{}
+	for metricName, value := range metrics.GetAllMetrics() {
+		values[metricName] = value
+	}
+
+	return &MetricsRecorder{
+		tb:      tb,
+		metrics: metrics,
+		values:  values,
+	}
+}
+
+// AssertDelta Assert how much a Counter or Fraction metric changed since it
+// was recorded
+//
+// metricName Name of the metric
+// want Expected change, int for Counter and float64 for Fraction metrics
+func (r *MetricsRecorder) AssertDelta(metricName string, want interface{}) {
+	r.tb.Helper()
+
+	got, err := r.metrics.ReadMetric(metricName)
+	if err != nil {
+		r.tb.Errorf("metrics: %s", err)
+		return
+	}
+
+	var delta interface{}
+	switch value := got.(type) {
+	case int:
+		before, _ := r.values[metricName].(int)
+		delta = value - before
+	case float64:
+		before, _ := r.values[metricName].(float64)
+		delta = value - before
+	default:
+		r.tb.Errorf("metrics: %s is a %T, only Counter and Fraction metrics have deltas", metricName, got)
+		return
+	}
+
+	if !reflect.DeepEqual(delta, want) {
+		r.tb.Errorf("metrics: %s changed by %v (%T), want %v (%T)", metricName, delta, delta, want, want)
+	}
+}
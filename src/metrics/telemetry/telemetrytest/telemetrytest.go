@@ -0,0 +1,236 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+// Package telemetrytest provides utilities to test code instrumented with
+// telemetry and metrics without parsing JSON
+//
+//	func TestTaskA(t *testing.T) {
+//		recorder := telemetrytest.New(t)
+//
+//		taskA(recorder.Context())
+//
+//		recorder.AssertCalls("taskB()", "taskA()", 1)
+//		recorder.AssertTree(`
+//			taskA()
+//			  taskB()
+//		`)
+//	}
+package telemetrytest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	gometrics "metrics"
+	"metrics/clock"
+	"metrics/telemetry"
+)
+
+// Name of the root of the recorded trees
+const rootName = "telemetrytest.Root()"
+
+// Recorder Isolated Telemetry capturing the calls traced during a test
+//
+// It replaces the global Telemetry until the end of the test, so tests
+// using it must not run in parallel
+type Recorder struct {
+	tb        testing.TB
+	Telemetry *telemetry.Telemetry
+	// Clock Manual clock of the Telemetry, starting at the Unix epoch. Both
+	// ends of the traced calls are read from it, advance it in the code
+	// under test to simulate slow calls.
+	Clock *clock.Manual
+}
+
+// New Create an enabled, isolated Telemetry for a test
+//
+// The global Telemetry is restored when the test finishes
+func New(tb testing.TB) *Recorder {
+	recorder := &Recorder{
+		tb:        tb,
+		Telemetry: telemetry.NewTelemetry(),
+		Clock:     clock.NewManual(time.Unix(0, 0)),
+	}
+	recorder.Telemetry.SetClock(recorder.Clock)
+	recorder.Telemetry.SetRoot(rootName)
+	recorder.Telemetry.Enable()
+
+	previous := telemetry.SetGlobal(recorder.Telemetry)
+	tb.Cleanup(func() {
+		telemetry.SetGlobal(previous)
+	})
+	return recorder
+}
+
+// Context Get the root context to pass to the code under test
+func (r *Recorder) Context() telemetry.Context {
+	return telemetry.Context{
+		FunctionName: rootName,
+	}
+}
+
+// Tree Get the tree of traced calls
+func (r *Recorder) Tree() gometrics.FunctionTracerMetricsDTO {
+	return r.Telemetry.GetMetrics()
+}
+
+// TreeString Get the structure of the tree of traced calls, one call per
+// line indented by depth, without call ID suffixes. This is the format
+// expected by AssertTree.
+func (r *Recorder) TreeString() string {
+	tree := r.Tree()
+	builder := &strings.Builder{}
+	for _, child := range tree.Children {
+		writeTree(builder, child, 0)
+	}
+	return builder.String()
+}
+
+// writeTree Write a call (and its children) in the golden format
+func writeTree(builder *strings.Builder, node *gometrics.FunctionTracerMetricsDTO, depth int) {
+	fmt.Fprintf(builder, "%s%s\n", strings.Repeat("  ", depth), gometrics.GetName(node.Function))
+	for _, child := range node.Children {
+		writeTree(builder, child, depth+1)
+	}
+}
+
+// AssertCalls Assert the number of calls to a function made by another one
+//
+// Names match either the full function name or its trailing part (ex.
+// "taskA()" or "main.taskA()" match "main.taskA()")
+//
+// function Name of the called function
+// parent Name of the caller, empty for any caller
+// want Expected number of calls
+func (r *Recorder) AssertCalls(function string, parent string, want int) {
+	r.tb.Helper()
+
+	tree := r.Tree()
+	calls := countCalls(&tree, function, parent)
+	if calls != want {
+		if parent == "" {
+			r.tb.Errorf("telemetry: %s was called %d times, want %d\n%s", function, calls, want, r.TreeString())
+			return
+		}
+		r.tb.Errorf("telemetry: %s was called %d times under %s, want %d\n%s", function, calls, parent, want,
+			r.TreeString())
+	}
+}
+
+// countCalls Count the calls to a function in a subtree
+func countCalls(node *gometrics.FunctionTracerMetricsDTO, function string, parent string) int {
+	calls := 0
+	for _, child := range node.Children {
+		if matchName(child.Function, function) && (parent == "" || matchName(node.Function, parent)) {
+			calls += child.Calls
+		}
+		calls += countCalls(child, function, parent)
+	}
+	return calls
+}
+
+// AssertMaxDuration Assert that no traced call took longer than max
+func (r *Recorder) AssertMaxDuration(max time.Duration) {
+	r.tb.Helper()
+
+	tree := r.Tree()
+	for _, slow := range findSlowCalls(&tree, max) {
+		r.tb.Errorf("telemetry: %s took %s, want at most %s", gometrics.GetName(slow.Function),
+			slow.EndTime.Sub(slow.StartTime), max)
+	}
+}
+
+// findSlowCalls Find the calls of a subtree longer than max
+func findSlowCalls(node *gometrics.FunctionTracerMetricsDTO, max time.Duration) []*gometrics.FunctionTracerMetricsDTO {
+	slow := []*gometrics.FunctionTracerMetricsDTO{}
+	for _, child := range node.Children {
+		if child.EndTime.Sub(child.StartTime) > max {
+			slow = append(slow, child)
+		}
+		slow = append(slow, findSlowCalls(child, max)...)
+	}
+	return slow
+}
+
+// AssertTree Assert the structure of the tree of traced calls
+//
+// golden One call per line, children indented deeper than their caller.
+// Call ID suffixes are ignored, names match as in AssertCalls and the
+// common indentation is removed so raw string literals can be used.
+func (r *Recorder) AssertTree(golden string) {
+	r.tb.Helper()
+
+	want := parseGolden(golden)
+	tree := r.Tree()
+	if mismatch := compareTree(tree.Children, want, ""); mismatch != "" {
+		r.tb.Errorf("telemetry: tree mismatch: %s\ngot:\n%s", mismatch, r.TreeString())
+	}
+}
+
+// goldenNode One call of a golden tree
+type goldenNode struct {
+	name     string
+	children []*goldenNode
+}
+
+// parseGolden Parse an indented golden tree
+func parseGolden(golden string) []*goldenNode {
+	root := &goldenNode{}
+	// stack of the last node seen at each indentation
+	type level struct {
+		indent int
+		node   *goldenNode
+	}
+	stack := []level{{indent: -1, node: root}}
+	for _, line := range strings.Split(golden, "\n") {
+		name := strings.TrimSpace(line)
+		if name == "" {
+			continue
+		}
+		indent := len(strings.TrimRight(line, " \t")) - len(name)
+		for len(stack) > 1 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		node := &goldenNode{name: name}
+		parent := stack[len(stack)-1].node
+		parent.children = append(parent.children, node)
+		stack = append(stack, level{indent: indent, node: node})
+	}
+	return root.children
+}
+
+// compareTree Compare traced calls against a golden tree
+//
+// returns a description of the first difference, empty if they match
+func compareTree(got []*gometrics.FunctionTracerMetricsDTO, want []*goldenNode, path string) string {
+	for i := 0; i < len(got) || i < len(want); i++ {
+		switch {
+		case i >= len(want):
+			return fmt.Sprintf("unexpected call %s%s", path, gometrics.GetName(got[i].Function))
+		case i >= len(got):
+			return fmt.Sprintf("missing call %s%s", path, want[i].name)
+		case !matchName(got[i].Function, want[i].name):
+			return fmt.Sprintf("got call %s%s, want %s", path, gometrics.GetName(got[i].Function), want[i].name)
+		}
+		childPath := path + want[i].name + telemetry.CallPathSeparator
+		if mismatch := compareTree(got[i].Children, want[i].children, childPath); mismatch != "" {
+			return mismatch
+		}
+	}
+	return ""
+}
+
+// matchName Check whether a traced function matches an expected name
+func matchName(function string, name string) bool {
+	function = gometrics.GetName(function)
+	return function == name || strings.HasSuffix(function, "."+name) || strings.HasSuffix(function, "/"+name)
+}
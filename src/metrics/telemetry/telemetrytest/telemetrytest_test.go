@@ -0,0 +1,87 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package telemetrytest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"metrics/telemetry"
+)
+
+// failureRecorder testing.TB recording the failures instead of reporting
+// them, so the assertions themselves can be tested
+type failureRecorder struct {
+	testing.TB
+	failures []string
+}
+
+func (f *failureRecorder) Helper() {}
+
+func (f *failureRecorder) Errorf(format string, args ...interface{}) {
+	f.failures = append(f.failures, fmt.Sprintf(format, args...))
+}
+
+// slowTask Traced task taking 50ms of the recorder clock
+//
+// The start is passed as instrumented code does, from the real clock: the
+// duration must still be measured on the recorder clock
+func slowTask(context telemetry.Context, recorder *Recorder) {
+	newContext := telemetry.FunctionName(context)
+	defer telemetry.IncreaseFunctionTracer(newContext, time.Now())
+
+	recorder.Clock.Advance(50 * time.Millisecond)
+}
+
+func TestAssertMaxDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		max      time.Duration
+		failures int
+	}{
+		{name: "slow call", max: 10 * time.Millisecond, failures: 1},
+		{name: "exact duration", max: 50 * time.Millisecond, failures: 0},
+		{name: "fast enough", max: time.Second, failures: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tb := &failureRecorder{TB: t}
+			recorder := New(tb)
+
+			slowTask(recorder.Context(), recorder)
+			recorder.AssertMaxDuration(test.max)
+
+			if len(tb.failures) != test.failures {
+				t.Fatalf("got failures %q, want %d", tb.failures, test.failures)
+			}
+		})
+	}
+}
+
+func TestAssertCalls(t *testing.T) {
+	tb := &failureRecorder{TB: t}
+	recorder := New(tb)
+
+	slowTask(recorder.Context(), recorder)
+	slowTask(recorder.Context(), recorder)
+
+	recorder.AssertCalls("slowTask()", "", 2)
+	recorder.AssertCalls("slowTask()", rootName, 2)
+	if len(tb.failures) != 0 {
+		t.Fatalf("unexpected failures %q", tb.failures)
+	}
+
+	recorder.AssertCalls("slowTask()", "", 1)
+	if len(tb.failures) != 1 {
+		t.Fatalf("got failures %q, want 1", tb.failures)
+	}
+}
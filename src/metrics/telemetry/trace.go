@@ -0,0 +1,83 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package telemetry
+
+import (
+	"strings"
+)
+
+// Trace helpers
+//
+// They replace the FunctionName/IsEnabled/defer boilerplate:
+//
+//	func taskA(context telemetry.Context) error {
+//		return telemetry.Trace(context, "", func(context telemetry.Context) error {
+//			...
+//		})
+//	}
+//
+// and produce the same tree as the manual pattern
+
+// getTraceName Get the node name for a traced call
+//
+// name Explicit name of the node, if empty the name of the function
+// calling the Trace helper is used
+func getTraceName(name string) string {
+	if name == "" {
+		// 0: getFunctionName, 1: getTraceName, 2: Trace helper, 3: caller
+		return getFunctionName(3)
+	}
+
+	// Node names must end with a parenthesis so the call ID suffix
+	// can be told apart
+	if !strings.HasSuffix(name, ")") {
+		name += "()"
+	}
+	return name
+}
+
+// Trace Trace a call to fn as a child of context in the global Telemetry
+//
+// fn receives the context to pass on to its own traced calls. When
+// telemetry is disabled fn is called straight away with context.
+//
+// context Context of the caller
+// name Name of the node, empty to use the calling function's name
+// fn Function to trace
+// returns the error returned by fn
+func Trace(context Context, name string, fn func(Context) error) (err error) {
+	if !IsEnabled() {
+		return fn(context)
+	}
+
+	newContext := newChildContext(context, getTraceName(name))
+	defer End(newContext, &err)
+
+	return fn(newContext)
+}
+
+// TraceValue Trace a call to fn returning a value as a child of context in
+// the global Telemetry
+//
+// context Context of the caller
+// name Name of the node, empty to use the calling function's name
+// fn Function to trace
+// returns the value and error returned by fn
+func TraceValue[T any](context Context, name string, fn func(Context) (T, error)) (value T, err error) {
+	if !IsEnabled() {
+		return fn(context)
+	}
+
+	newContext := newChildContext(context, getTraceName(name))
+	defer End(newContext, &err)
+
+	return fn(newContext)
+}
@@ -0,0 +1,314 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"metrics/clock"
+	metricTypes "metrics/metrictypes"
+)
+
+// ExpiredSeriesMetric Counter of the series removed because their TTL
+// elapsed, added by SetTTL
+const ExpiredSeriesMetric = "metrics_expired_series"
+
+// defaultJanitorInterval Interval of a janitor started without an interval
+// before any TTL is set
+const defaultJanitorInterval = time.Minute
+
+// ttlState TTLs and clock of the Metrics, published for the updates that do
+// not take the lock
+type ttlState struct {
+	ttls  map[string]time.Duration
+	clock clock.Clock
+}
+
+// expiredSeries Series removed by an expiry, passed to the OnExpire
+// callbacks
+type expiredSeries struct {
+	name  string
+	value interface{}
+}
+
+// SetTTL sets how long a series (ex. of a connection or a peer) is kept
+// without being updated
+//
+// Expired series are removed when they are read, by ExpireMetrics and by the
+// janitor (see StartJanitor). Only the updates made through the Metrics
+// count, not those made to a Timer or Ratio returned by GetTimer or GetRatio.
+// Callback metrics (ex. GaugeFunc) never expire.
+//
+// metricName Name of a series, or of a metric to set the TTL of all its
+// series (ex. "peer_bytes" for `peer_bytes{peer="..."}`)
+// ttl Time without updates after which the series are removed, 0 to keep
+// them
+func (metric Metrics) SetTTL(metricName string, ttl time.Duration) {
+	metric.mutex.Lock()
+	if ttl > 0 {
+		metric.options.ttls[metricName] = ttl
+	} else {
+		delete(metric.options.ttls, metricName)
+	}
+	metric.publishTTLs()
+	metric.mutex.Unlock()
+
+	if ttl > 0 {
+		metric.AddCounterFunc(ExpiredSeriesMetric, func() int {
+			return int(metric.ExpiredSeries())
+		})
+	}
+}
+
+// OnExpire adds a callback called with the last value of every expired
+// series
+//
+// Callbacks are called without holding the lock of the Metrics, so they can
+// update other metrics.
+//
+// callback Function called with the name and value of the series
+func (metric Metrics) OnExpire(callback func(metricName string, value interface{})) {
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+
+	metric.options.onExpire = append(metric.options.onExpire, callback)
+}
+
+// ExpiredSeries returns the number of series removed because their TTL
+// elapsed
+func (metric Metrics) ExpiredSeries() uint64 {
+	return atomic.LoadUint64(&metric.options.expiredSeries)
+}
+
+// ExpireMetrics removes the series whose TTL elapsed now
+//
+// returns the number of series removed
+func (metric Metrics) ExpireMetrics() int {
+	metric.mutex.Lock()
+	expired := metric.expire(metric.metricData.GetMetricsNames())
+	metric.mutex.Unlock()
+
+	metric.evicted(expired)
+	return len(expired)
+}
+
+// StartJanitor removes the expired series on every interval, in the
+// background
+//
+// interval Time between the expiries, the shortest TTL set (or a minute if
+// none is set) if it is 0 or negative
+// returns a function stopping the janitor, that waits for the current
+// expiry
+func (metric Metrics) StartJanitor(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = metric.shortestTTL()
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				metric.ExpireMetrics()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		<-stopped
+	}
+}
+
+// shortestTTL Shortest TTL set, or defaultJanitorInterval if none is set
+func (metric Metrics) shortestTTL() time.Duration {
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+
+	shortest := time.Duration(0)
+	for _, ttl := range metric.options.ttls {
+		if shortest == 0 || ttl < shortest {
+			shortest = ttl
+		}
+	}
+	if shortest == 0 {
+		return defaultJanitorInterval
+	}
+	return shortest
+}
+
+// publishTTLs Publish a copy of the TTLs and the clock for the updates that
+// do not take the lock, the lock must be held
+func (metric Metrics) publishTTLs() {
+	state := &ttlState{ttls: make(map[string]time.Duration, len(metric.options.ttls)), clock: metric.options.clock}
+	for metricName, ttl := range metric.options.ttls {
+		state.ttls[metricName] = ttl
+	}
+	metric.options.published.Store(state)
+}
+
+// publishedTTLs returns the TTLs published by publishTTLs, nil if no series
+// has a TTL
+func (metric Metrics) publishedTTLs() *ttlState {
+	state, _ := metric.options.published.Load().(*ttlState)
+	if state == nil || len(state.ttls) == 0 {
+		return nil
+	}
+	return state
+}
+
+// ttl TTL of a series, set for the series or its metric, the lock must be
+// held
+func (metric Metrics) ttl(metricName string) time.Duration {
+	return lookupTTL(metric.options.ttls, metricName)
+}
+
+// lookupTTL TTL of a series, set for the series or its metric
+//
+// ttls TTLs by series or metric name
+func lookupTTL(ttls map[string]time.Duration, metricName string) time.Duration {
+	if ttl, ok := ttls[metricName]; ok {
+		return ttl
+	}
+	return ttls[metricTypes.FamilyName(metricName)]
+}
+
+// touch Record the update of a series with a TTL, the lock must be held
+func (metric Metrics) touch(metricName string) {
+	if len(metric.options.ttls) == 0 || metric.ttl(metricName) <= 0 {
+		return
+	}
+
+	if _, _, exists := metric.metricData.CountSeries(metricName); !exists {
+		metric.options.lastUpdates.Delete(metricName)
+		return
+	}
+	nanos := metric.options.clock.Now().UnixNano()
+	if lastUpdate, ok := metric.options.lastUpdates.Load(metricName); ok {
+		atomic.StoreInt64(lastUpdate.(*int64), nanos)
+		return
+	}
+	metric.options.lastUpdates.Store(metricName, &nanos)
+}
+
+// markUpdated Record the update of a series made without holding the lock
+// (ex. by MarkMeter), if it has a TTL
+//
+// Only the first update of a series takes the lock, to add it to the
+// tracked series, the next ones store the time atomically.
+func (metric Metrics) markUpdated(metricName string) {
+	state := metric.publishedTTLs()
+	if state == nil || lookupTTL(state.ttls, metricName) <= 0 {
+		return
+	}
+	if lastUpdate, ok := metric.options.lastUpdates.Load(metricName); ok {
+		atomic.StoreInt64(lastUpdate.(*int64), state.clock.Now().UnixNano())
+		return
+	}
+
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+	metric.touch(metricName)
+}
+
+// expireLazily Remove the expired series before they are read, if any
+// series has a TTL
+//
+// metricNames Names of the series read, all of them if empty
+func (metric Metrics) expireLazily(metricNames ...string) {
+	if metric.publishedTTLs() == nil {
+		return
+	}
+
+	metric.mutex.Lock()
+	if len(metricNames) == 0 {
+		metricNames = metric.metricData.GetMetricsNames()
+	}
+	expired := metric.expire(metricNames)
+	metric.mutex.Unlock()
+
+	metric.evicted(expired)
+}
+
+// expire Remove the series whose TTL elapsed, the lock must be held
+//
+// The series seen for the first time (ex. added before their TTL was set)
+// start being tracked instead.
+//
+// metricNames Names of the series to check
+// returns the series removed
+func (metric Metrics) expire(metricNames []string) []expiredSeries {
+	if len(metric.options.ttls) == 0 {
+		return nil
+	}
+
+	now := metric.options.clock.Now()
+	expired := []expiredSeries{}
+	for _, metricName := range metricNames {
+		ttl := metric.ttl(metricName)
+		if ttl <= 0 || metric.metricData.IsCallbackMetric(metricName) {
+			continue
+		}
+		if _, _, exists := metric.metricData.CountSeries(metricName); !exists {
+			continue
+		}
+
+		lastUpdate, ok := metric.options.lastUpdates.Load(metricName)
+		if !ok {
+			nanos := now.UnixNano()
+			metric.options.lastUpdates.Store(metricName, &nanos)
+			continue
+		}
+		if now.Sub(time.Unix(0, atomic.LoadInt64(lastUpdate.(*int64)))) < ttl {
+			continue
+		}
+
+		// Only values and computed metrics with their own lock are left,
+		// so reading them with the lock held is safe
+		value, _ := metric.metricData.GetMetricValue(metricName)
+		metric.change(metricName, func() error {
+			metric.metricData.DeleteMetric(metricName)
+			return nil
+		})
+		metric.options.lastUpdates.Delete(metricName)
+		atomic.AddUint64(&metric.options.expiredSeries, 1)
+		expired = append(expired, expiredSeries{name: metricName, value: value})
+	}
+	return expired
+}
+
+// evicted Call the OnExpire callbacks with the expired series, called
+// without holding the lock
+func (metric Metrics) evicted(expired []expiredSeries) {
+	if len(expired) == 0 {
+		return
+	}
+
+	metric.mutex.Lock()
+	callbacks := append([]func(string, interface{}){}, metric.options.onExpire...)
+	metric.mutex.Unlock()
+
+	for _, series := range expired {
+		for _, callback := range callbacks {
+			callback(series.name, series.value)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// (c) Copyright 2022 Hewlett Packard Enterprise Development LP
+//
+// Confidential computer software. Valid license from Hewlett Packard
+// Enterprise required for possession, use or copying.
+//
+// Consistent with FAR 12.211 and 12.212, Commercial Computer Software,
+// Computer Software Documentation, and Technical Data for Commercial Items
+// are licensed to the U.S. Government under vendor's standard commercial
+// license.
+
+package metrics
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultWatchBuffer Number of changes buffered for a subscription before
+// they are dropped
+const DefaultWatchBuffer = 256
+
+// MetricChange Change of the value of a metric
+type MetricChange struct {
+	// Name Name of the metric
+	Name string
+	// Old Value before the change, nil if the metric was added
+	Old interface{}
+	// New Value after the change, nil if the metric was deleted
+	New interface{}
+	// Time Time of the change, from the clock of the Metrics
+	Time time.Time
+}
+
+// Subscription Changes of the metrics matching a filter, delivered
+// asynchronously through a bounded buffer
+//
+// Updates never wait for subscribers: the changes are queued for the
+// goroutine of the subscription, which filters them and sends them to the
+// buffer. When the queue or the buffer is full, the changes are dropped and
+// counted, so a slow filter or reader only delays its own subscription.
+type Subscription struct {
+	metrics Metrics
+	filter  func(metricName string) bool
+	changes chan MetricChange
+	// Guards the queue and serializes the sends of the changes and the
+	// closing of their channel
+	mutex sync.Mutex
+	// Changes waiting for the filter, in the order of the updates, at most
+	// the size of the buffer
+	queue      []MetricChange
+	delivering bool
+	dropped    uint64
+	closed     uint32
+	closeOnce  sync.Once
+}
+
+// Subscribe returns a subscription to the changes of the metrics
+//
+// The changes are made by IncreaseMetricValue, DecreaseMetricValue,
+// SetMetric, ObserveMetric (Histogram metrics), ResetMetric,
+// ResetAllMetrics, DeleteMetric and the Add methods. Computed metrics
+// (callbacks, Meter and Window) are not watched, their values change
+// without updates.
+//
+// filter Whether to watch a metric, all of them if nil. It is called
+// without the lock of the Metrics, on the goroutine delivering the changes
+// of the subscription, so it can read the metrics. While it blocks, the
+// changes are queued (up to the buffer size) and then dropped.
+// buffer Number of changes queued and buffered, DefaultWatchBuffer if 0 or
+// less
+func (metric Metrics) Subscribe(filter func(metricName string) bool, buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultWatchBuffer
+	}
+	subscription := &Subscription{
+		metrics: metric,
+		filter:  filter,
+		changes: make(chan MetricChange, buffer),
+	}
+
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+
+	metric.options.subscriptions = append(metric.options.subscriptions, subscription)
+	return subscription
+}
+
+// Watch calls a function on every change of a metric
+//
+// The function is called on a goroutine of the subscription, one change at
+// a time in the order of the changes, so it can read and update the metrics.
+// Changes are buffered (up to DefaultWatchBuffer) while it runs.
+//
+// metricName Name of the metric to watch
+// callback Function receiving the values before and after each change
+// returns the subscription, to be closed to stop watching
+func (metric Metrics) Watch(metricName string, callback func(old interface{}, new interface{})) *Subscription {
+	subscription := metric.Subscribe(func(name string) bool { return name == metricName }, 0)
+	go func() {
+		for change := range subscription.changes {
+			if atomic.LoadUint32(&subscription.closed) == 0 {
+				callback(change.Old, change.New)
+			}
+		}
+	}()
+	return subscription
+}
+
+// Changes returns the channel of the changes, closed by Close
+func (s *Subscription) Changes() <-chan MetricChange {
+	return s.changes
+}
+
+// Dropped returns the number of changes dropped because the queue or the
+// buffer was full, the changes dropped from the queue are counted before
+// being filtered
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close stops the subscription and closes its channel
+//
+// The changes already buffered are still delivered to Changes, but no
+// longer to the Watch callback. Close can be called more than once, and
+// from a Watch callback.
+func (s *Subscription) Close() {
+	atomic.StoreUint32(&s.closed, 1)
+	s.closeOnce.Do(func() {
+		s.metrics.mutex.Lock()
+		subscriptions := s.metrics.options.subscriptions
+		for i, subscription := range subscriptions {
+			if subscription == s {
+				s.metrics.options.subscriptions = append(subscriptions[:i:i], subscriptions[i+1:]...)
+				break
+			}
+		}
+		s.metrics.mutex.Unlock()
+
+		// Changes queued before are dropped, send drops the one being
+		// delivered
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.queue = nil
+		close(s.changes)
+	})
+}
+
+// enqueue Queue a change for the goroutine of the subscription, or drop it
+// if the queue is full, without blocking
+func (s *Subscription) enqueue(change MetricChange) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if atomic.LoadUint32(&s.closed) != 0 {
+		return
+	}
+	if len(s.queue) >= cap(s.changes) {
+		atomic.AddUint64(&s.dropped, 1)
+		return
+	}
+	s.queue = append(s.queue, change)
+	if !s.delivering {
+		s.delivering = true
+		go s.deliver()
+	}
+}
+
+// deliver Deliver the queued changes in order, until the queue is empty
+func (s *Subscription) deliver() {
+	for {
+		s.mutex.Lock()
+		if len(s.queue) == 0 {
+			s.delivering = false
+			s.mutex.Unlock()
+			return
+		}
+		next := s.queue[0]
+		s.queue[0] = MetricChange{}
+		s.queue = s.queue[1:]
+		s.mutex.Unlock()
+
+		s.send(next)
+	}
+}
+
+// send Send a change if it matches the filter, without blocking
+func (s *Subscription) send(change MetricChange) {
+	if s.filter != nil && !s.filter(change.Name) {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if atomic.LoadUint32(&s.closed) != 0 {
+		return
+	}
+	select {
+	case s.changes <- change:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// watchedValue Value of a metric for the subscriptions, nil if it does not
+// exist or is computed, the lock must be held
+func (metric Metrics) watchedValue(metricName string) interface{} {
+	if metric.metricData.IsComputedMetric(metricName) {
+		return nil
+	}
+	value, _ := metric.metricData.GetMetricValue(metricName)
+	return value
+}
+
+// change Update a metric, notify the subscriptions watching it and record
+// the update for its TTL, the lock must be held
+//
+// metricName Name of the metric
+// update Function updating the metric
+func (metric Metrics) change(metricName string, update func() error) error {
+	if len(metric.options.subscriptions) == 0 {
+		if err := update(); err != nil {
+			return err
+		}
+		metric.touch(metricName)
+		return nil
+	}
+
+	old := metric.watchedValue(metricName)
+	if err := update(); err != nil {
+		return err
+	}
+	metric.touch(metricName)
+	metric.notify(metricName, old, metric.watchedValue(metricName))
+	return nil
+}
+
+// changeAll Update all the metrics and notify the subscriptions watching
+// them, the lock must be held
+//
+// update Function updating the metrics
+func (metric Metrics) changeAll(update func()) {
+	if len(metric.options.subscriptions) == 0 {
+		update()
+		return
+	}
+
+	old := map[string]interface{}{}
+	for _, metricName := range metric.metricData.GetMetricsNames() {
+		old[metricName] = metric.watchedValue(metricName)
+	}
+	update()
+	for metricName, value := range old {
+		metric.notify(metricName, value, metric.watchedValue(metricName))
+	}
+}
+
+// notify Queue a change for the subscriptions, the lock must be held
+//
+// The filters of the subscriptions are only called when the change is
+// delivered, without the lock
+func (metric Metrics) notify(metricName string, oldValue interface{}, newValue interface{}) {
+	if reflect.DeepEqual(oldValue, newValue) {
+		return
+	}
+
+	change := MetricChange{Name: metricName, Old: oldValue, New: newValue, Time: metric.options.clock.Now()}
+	for _, subscription := range metric.options.subscriptions {
+		subscription.enqueue(change)
+	}
+}